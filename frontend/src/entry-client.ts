@@ -10,4 +10,5 @@ app.config.globalProperties.$fetchFn = createAxiosInstance()
 
 router.push(window.location.pathname)
 
-app.mount('#app', true)
+// a failed streamed render leaves no usable html, render from scratch
+app.mount('#app', !window.__SSR_ERROR__)
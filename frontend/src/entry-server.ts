@@ -1,11 +1,11 @@
-import { renderToString } from '@vue/server-renderer'
+import { renderToString, renderToSimpleStream } from '@vue/server-renderer'
 import { makeApp } from './app'
 import { createAxiosInstance } from '@/utils/axios.ts'
 import { renderSSRHead } from '@unhead/ssr'
 
 declare function dumpObject(obj: any): string;
 
-(globalThis as any).v8goRenderToString = async function (ctx: any) {
+async function setupApp(ctx: any) {
   const { app, router, store, head } = makeApp()
 
   await router.push(ctx.url)
@@ -21,7 +21,10 @@ declare function dumpObject(obj: any): string;
 
   app.config.globalProperties.$fetchFn = createAxiosInstance(ctx)
 
-  const html = await renderToString(app, ctx)
+  return { app, store, head }
+}
+
+async function finishRender(ctx: any, store: any, head: any) {
   const {headTags} = await renderSSRHead(head)
 
   ctx.htmlState = store.state.value
@@ -32,6 +35,35 @@ declare function dumpObject(obj: any): string;
   }
 
   store.state.value = {}
+}
+
+(globalThis as any).v8goRenderToString = async function (ctx: any) {
+  const { app, store, head } = await setupApp(ctx)
+
+  const html = await renderToString(app, ctx)
+  await finishRender(ctx, store, head)
 
   return html
 }
+
+(globalThis as any).v8goRenderToStream = async function (ctx: any, push: (chunk: string) => void) {
+  const { app, store, head } = await setupApp(ctx)
+
+  await new Promise<void>((resolve, reject) => {
+    renderToSimpleStream(app, ctx, {
+      push(chunk: string | null) {
+        if (chunk === null) {
+          resolve()
+        } else if (chunk !== '') {
+          push(chunk)
+        }
+      },
+      destroy(err: any) {
+        reject(err)
+      },
+    })
+  })
+  await finishRender(ctx, store, head)
+
+  return ''
+}
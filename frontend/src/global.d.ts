@@ -2,5 +2,6 @@ export {};
 declare global {
   interface Window {
     __INITIAL_STATE__: any;
+    __SSR_ERROR__?: boolean;
   }
 }
@@ -4,7 +4,9 @@ go 1.24.2
 
 require (
 	github.com/BurntSushi/toml v1.5.0
+	github.com/andybalholm/brotli v1.2.0
 	github.com/lizc2003/v8go v0.7.0
+	golang.org/x/text v0.25.0
 )
 
 require (
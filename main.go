@@ -3,8 +3,10 @@ package main
 import (
 	"github.com/lizc2003/vue-ssr-v8go/server/common/defs"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/trace"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/util"
 	"github.com/lizc2003/vue-ssr-v8go/server/logic"
+	"os"
 )
 
 func main() {
@@ -15,6 +17,17 @@ func main() {
 	}
 
 	tlog.Init(&c.Log, defs.App, "")
-	logic.RunServer(&c)
+	if c.AccessLog.FileName != "" && c.Prerender == "" {
+		tlog.InitAccess(&c.AccessLog, defs.App)
+	}
+	if c.Prerender != "" {
+		bOK = logic.RunPrerender(&c)
+	} else {
+		logic.RunServer(&c)
+	}
+	trace.Close()
 	tlog.Close()
+	if !bOK {
+		os.Exit(1)
+	}
 }
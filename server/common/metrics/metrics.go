@@ -0,0 +1,298 @@
+package metrics
+
+// A minimal implementation of the Prometheus text exposition format.
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	name() string
+	write(w *bytes.Buffer)
+}
+
+var gRegistry = struct {
+	mutex      sync.Mutex
+	collectors []collector
+}{}
+
+func register(c collector) {
+	gRegistry.mutex.Lock()
+	gRegistry.collectors = append(gRegistry.collectors, c)
+	sort.Slice(gRegistry.collectors, func(i, j int) bool {
+		return gRegistry.collectors[i].name() < gRegistry.collectors[j].name()
+	})
+	gRegistry.mutex.Unlock()
+}
+
+func WriteText(w *bytes.Buffer) {
+	gRegistry.mutex.Lock()
+	collectors := gRegistry.collectors
+	gRegistry.mutex.Unlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+func Handler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var w bytes.Buffer
+		WriteText(&w)
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writer.Header().Set("Content-Length", strconv.Itoa(w.Len()))
+		writer.Write(w.Bytes())
+	})
+}
+
+////////////////////////////////////////////
+
+type metricVec struct {
+	metricName string
+	help       string
+	metricType string
+	labelNames []string
+	mutex      sync.Mutex
+	values     map[string]*metricValue
+}
+
+type metricValue struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+func (this *metricVec) init(name, help, metricType string, labelNames []string) {
+	this.metricName = name
+	this.help = help
+	this.metricType = metricType
+	this.labelNames = labelNames
+	this.values = make(map[string]*metricValue)
+}
+
+func (this *metricVec) name() string {
+	return this.metricName
+}
+
+// getValue must be called with the mutex held.
+func (this *metricVec) getValue(labelValues []string) *metricValue {
+	key := strings.Join(labelValues, "\xff")
+	v, ok := this.values[key]
+	if !ok {
+		v = &metricValue{labelValues: append([]string(nil), labelValues...)}
+		this.values[key] = v
+	}
+	return v
+}
+
+func (this *metricVec) Delete(labelValues ...string) {
+	this.mutex.Lock()
+	delete(this.values, strings.Join(labelValues, "\xff"))
+	this.mutex.Unlock()
+}
+
+func (this *metricVec) writeHeader(w *bytes.Buffer) {
+	w.WriteString("# HELP ")
+	w.WriteString(this.metricName)
+	w.WriteByte(' ')
+	w.WriteString(this.help)
+	w.WriteString("\n# TYPE ")
+	w.WriteString(this.metricName)
+	w.WriteByte(' ')
+	w.WriteString(this.metricType)
+	w.WriteByte('\n')
+}
+
+func (this *metricVec) sortedValues() []*metricValue {
+	values := make([]*metricValue, 0, len(this.values))
+	for _, v := range this.values {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return strings.Join(values[i].labelValues, "\xff") < strings.Join(values[j].labelValues, "\xff")
+	})
+	return values
+}
+
+func (this *metricVec) writeSample(w *bytes.Buffer, suffix string, labelValues []string, extraName, extraValue string, value float64) {
+	w.WriteString(this.metricName)
+	w.WriteString(suffix)
+	if len(labelValues) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, lv := range labelValues {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, this.labelNames[i], lv)
+		}
+		if extraName != "" {
+			if len(labelValues) > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func writeLabel(w *bytes.Buffer, name, value string) {
+	w.WriteString(name)
+	w.WriteString(`="`)
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\':
+			w.WriteString(`\\`)
+		case '"':
+			w.WriteString(`\"`)
+		case '\n':
+			w.WriteString(`\n`)
+		default:
+			w.WriteByte(c)
+		}
+	}
+	w.WriteByte('"')
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+////////////////////////////////////////////
+
+type CounterVec struct {
+	metricVec
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{}
+	c.init(name, help, "counter", labelNames)
+	register(c)
+	return c
+}
+
+func (this *CounterVec) Add(delta float64, labelValues ...string) {
+	this.mutex.Lock()
+	this.getValue(labelValues).value += delta
+	this.mutex.Unlock()
+}
+
+func (this *CounterVec) Inc(labelValues ...string) {
+	this.Add(1, labelValues...)
+}
+
+func (this *CounterVec) write(w *bytes.Buffer) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.writeHeader(w)
+	for _, v := range this.sortedValues() {
+		this.writeSample(w, "", v.labelValues, "", "", v.value)
+	}
+}
+
+type GaugeVec struct {
+	metricVec
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{}
+	g.init(name, help, "gauge", labelNames)
+	register(g)
+	return g
+}
+
+func (this *GaugeVec) Set(value float64, labelValues ...string) {
+	this.mutex.Lock()
+	this.getValue(labelValues).value = value
+	this.mutex.Unlock()
+}
+
+func (this *GaugeVec) Add(delta float64, labelValues ...string) {
+	this.mutex.Lock()
+	this.getValue(labelValues).value += delta
+	this.mutex.Unlock()
+}
+
+func (this *GaugeVec) write(w *bytes.Buffer) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.writeHeader(w)
+	for _, v := range this.sortedValues() {
+		this.writeSample(w, "", v.labelValues, "", "", v.value)
+	}
+}
+
+type GaugeFunc struct {
+	metricVec
+	fn func() float64
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{fn: fn}
+	g.init(name, help, "gauge", nil)
+	register(g)
+	return g
+}
+
+func (this *GaugeFunc) write(w *bytes.Buffer) {
+	this.writeHeader(w)
+	this.writeSample(w, "", nil, "", "", this.fn())
+}
+
+type HistogramVec struct {
+	metricVec
+	buckets []float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{buckets: buckets}
+	h.init(name, help, "histogram", labelNames)
+	register(h)
+	return h
+}
+
+func (this *HistogramVec) Observe(value float64, labelValues ...string) {
+	this.mutex.Lock()
+	v := this.getValue(labelValues)
+	if v.counts == nil {
+		v.counts = make([]uint64, len(this.buckets))
+	}
+	for i, b := range this.buckets {
+		if value <= b {
+			v.counts[i]++
+		}
+	}
+	v.sum += value
+	v.count++
+	this.mutex.Unlock()
+}
+
+func (this *HistogramVec) write(w *bytes.Buffer) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.writeHeader(w)
+	for _, v := range this.sortedValues() {
+		for i, b := range this.buckets {
+			this.writeSample(w, "_bucket", v.labelValues, "le", formatFloat(b), float64(v.counts[i]))
+		}
+		this.writeSample(w, "_bucket", v.labelValues, "le", "+Inf", float64(v.count))
+		this.writeSample(w, "_sum", v.labelValues, "", "", v.sum)
+		this.writeSample(w, "_count", v.labelValues, "", "", float64(v.count))
+	}
+}
@@ -0,0 +1,54 @@
+package tlog
+
+import (
+	"encoding/json"
+)
+
+var gAccessLogger *Logger
+
+type Field struct {
+	Key   string
+	Value any
+}
+
+// InitAccess creates the access logger. Its records are always json and
+// are written to their own file.
+func InitAccess(c *Config, serverName string) {
+	if gAccessLogger == nil {
+		c.check(serverName, "")
+		c.UseJson = true
+		l := newLogger(c, serverName)
+		if l != nil {
+			gAccessLogger = l
+		} else {
+			Error("init access logger failed")
+		}
+	}
+}
+
+func IsAccessEnabled() bool {
+	return gAccessLogger != nil
+}
+
+func Access(fields ...Field) {
+	if gAccessLogger == nil {
+		return
+	}
+	m := &Msg{level: INFO, fields: make([]field, 0, len(fields))}
+	for _, f := range fields {
+		b, err := json.Marshal(f.Value)
+		if err != nil {
+			continue
+		}
+		m.fields = append(m.fields, field{key: f.Key, value: b})
+	}
+
+	select {
+	case gAccessLogger.queue <- m:
+	default:
+	}
+}
+
+func Kv(key string, value any) Field {
+	return Field{Key: key, Value: value}
+}
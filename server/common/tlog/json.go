@@ -24,6 +24,17 @@ func (l *Logger) makeJsonLog(w *bytes.Buffer, msg *Msg) []byte {
 	w.WriteString(l.serverName)
 	w.WriteByte('"')
 
+	if msg.fields != nil {
+		for _, f := range msg.fields {
+			w.WriteString(",\"")
+			w.WriteString(f.key)
+			w.WriteString("\":")
+			w.Write(f.value)
+		}
+		w.WriteString("}\n")
+		return w.Bytes()
+	}
+
 	w.WriteString(",\"level\":")
 	w.WriteByte('"')
 	w.WriteString(levelText[msg.level])
@@ -33,10 +33,11 @@ type Logger struct {
 }
 
 type Msg struct {
-	line  string
-	file  string
-	level LEVEL
-	msg   []byte
+	line   string
+	file   string
+	level  LEVEL
+	msg    []byte
+	fields []field
 }
 
 type field struct {
@@ -66,6 +66,12 @@ func Init(c *Config, serverName string, suffix string) {
 }
 
 func Close() {
+	if gAccessLogger != nil {
+		tmp := gAccessLogger
+		gAccessLogger = nil
+		time.Sleep(100 * time.Millisecond)
+		tmp.stop()
+	}
 	if gLogger != stdLogger {
 		tmp := gLogger
 		gLogger = stdLogger
@@ -0,0 +1,306 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultServiceName   = "vue-ssr-v8go"
+	DefaultBatchSize     = 256
+	DefaultFlushInterval = 5
+	DefaultQueueSize     = 4096
+	ExportTimeout        = 10
+	ScopeName            = "github.com/lizc2003/vue-ssr-v8go"
+)
+
+type Config struct {
+	Endpoint      string   `toml:"endpoint"`
+	ServiceName   string   `toml:"service_name"`
+	SampleRatio   float64  `toml:"sample_ratio"`
+	BatchSize     int      `toml:"batch_size"`
+	FlushInterval int      `toml:"flush_interval"`
+	Headers       []string `toml:"headers"`
+}
+
+type tracer struct {
+	url         string
+	serviceName string
+	threshold   uint64
+	batchSize   int
+	interval    time.Duration
+	headers     map[string]string
+	client      *http.Client
+	queue       chan *Span
+	flushCh     chan chan struct{}
+	closeOnce   sync.Once
+	stopCh      chan struct{}
+	end         chan struct{}
+}
+
+var gTracer *tracer
+
+// Init enables tracing when an endpoint is configured. The endpoint is the
+// base url of an OTLP/HTTP collector, e.g. http://127.0.0.1:4318.
+func Init(c *Config) {
+	if c.Endpoint == "" || gTracer != nil {
+		return
+	}
+
+	serviceName := c.ServiceName
+	if serviceName == "" {
+		serviceName = DefaultServiceName
+	}
+	batchSize := c.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	interval := c.FlushInterval
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	headers := make(map[string]string)
+	for _, h := range c.Headers {
+		k, v, ok := strings.Cut(h, ":")
+		if ok {
+			headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+
+	url := strings.TrimSuffix(c.Endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+
+	t := &tracer{
+		url:         url,
+		serviceName: serviceName,
+		threshold:   sampleThreshold(c.SampleRatio),
+		batchSize:   batchSize,
+		interval:    time.Duration(interval) * time.Second,
+		headers:     headers,
+		client:      &http.Client{Timeout: ExportTimeout * time.Second},
+		queue:       make(chan *Span, DefaultQueueSize),
+		flushCh:     make(chan chan struct{}),
+		stopCh:      make(chan struct{}),
+		end:         make(chan struct{}),
+	}
+	go t.run()
+	gTracer = t
+	tlog.Infof("tracing enabled, exporting to %s, sample ratio: %v", url, c.SampleRatio)
+}
+
+// Close exports the pending spans and stops the exporter.
+func Close() {
+	if gTracer == nil {
+		return
+	}
+	t := gTracer
+	gTracer = nil
+	t.closeOnce.Do(func() {
+		close(t.stopCh)
+		<-t.end
+	})
+}
+
+// Flush exports the pending spans at once.
+func Flush() {
+	t := gTracer
+	if t == nil {
+		return
+	}
+	done := make(chan struct{})
+	t.flushCh <- done
+	<-done
+}
+
+func IsEnabled() bool {
+	return gTracer != nil
+}
+
+func (this *tracer) sample(id TraceId) bool {
+	return this.threshold != 0 && traceIdLow(id) <= this.threshold
+}
+
+func (this *tracer) export(span *Span) {
+	select {
+	case this.queue <- span:
+	default:
+	}
+}
+
+func (this *tracer) run() {
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, this.batchSize)
+	for {
+		select {
+		case span := <-this.queue:
+			batch = append(batch, span)
+			if len(batch) >= this.batchSize {
+				this.send(batch)
+				batch = batch[:0]
+			}
+		case done := <-this.flushCh:
+			batch = this.drain(batch)
+			this.send(batch)
+			batch = batch[:0]
+			close(done)
+		case <-this.stopCh:
+			this.send(this.drain(batch))
+			close(this.end)
+			return
+		case <-ticker.C:
+			this.send(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (this *tracer) drain(batch []*Span) []*Span {
+	for n := len(this.queue); n > 0; n-- {
+		batch = append(batch, <-this.queue)
+	}
+	return batch
+}
+
+func (this *tracer) send(spans []*Span) {
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(this.encode(spans))
+	if err != nil {
+		tlog.Error("trace encode error:", err)
+		return
+	}
+	request, err := http.NewRequest(http.MethodPost, this.url, bytes.NewReader(body))
+	if err != nil {
+		tlog.Error("trace export error:", err)
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for k, v := range this.headers {
+		request.Header.Set(k, v)
+	}
+	resp, err := this.client.Do(request)
+	if err != nil {
+		tlog.Error("trace export error:", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		tlog.Errorf("trace export error: %s, %d spans dropped", resp.Status, len(spans))
+	}
+}
+
+////////////////////////////////////////////
+// OTLP json encoding
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func (this *tracer) encode(spans []*Span) *otlpRequest {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceId:           hex.EncodeToString(span.sc.TraceId[:]),
+			SpanId:            hex.EncodeToString(span.sc.SpanId[:]),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: strconv.FormatInt(span.startTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.endTime.UnixNano(), 10),
+		}
+		if span.parent != (SpanId{}) {
+			s.ParentSpanId = hex.EncodeToString(span.parent[:])
+		}
+		for _, attr := range span.attrs {
+			s.Attributes = append(s.Attributes, encodeAttribute(attr.key, attr.value))
+		}
+		if span.status != 0 {
+			s.Status = &otlpStatus{Code: span.status, Message: span.message}
+		}
+		out = append(out, s)
+	}
+
+	return &otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpKeyValue{encodeAttribute("service.name", this.serviceName)},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: ScopeName},
+				Spans: out,
+			}},
+		}},
+	}
+}
+
+func encodeAttribute(key string, value any) otlpKeyValue {
+	var v map[string]any
+	switch value := value.(type) {
+	case string:
+		v = map[string]any{"stringValue": value}
+	case bool:
+		v = map[string]any{"boolValue": value}
+	case int:
+		v = map[string]any{"intValue": strconv.FormatInt(int64(value), 10)}
+	case int32:
+		v = map[string]any{"intValue": strconv.FormatInt(int64(value), 10)}
+	case int64:
+		v = map[string]any{"intValue": strconv.FormatInt(value, 10)}
+	case float64:
+		v = map[string]any{"doubleValue": value}
+	default:
+		b, _ := json.Marshal(value)
+		v = map[string]any{"stringValue": string(b)}
+	}
+	return otlpKeyValue{Key: key, Value: v}
+}
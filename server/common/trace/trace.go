@@ -0,0 +1,204 @@
+package trace
+
+// A minimal OpenTelemetry tracer exporting spans with OTLP/HTTP in the json
+// encoding. https://opentelemetry.io/docs/specs/otlp/
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	SpanKindInternal = 1
+	SpanKindServer   = 2
+	SpanKindClient   = 3
+
+	StatusCodeOk    = 1
+	StatusCodeError = 2
+)
+
+type TraceId [16]byte
+type SpanId [8]byte
+
+type SpanContext struct {
+	TraceId TraceId
+	SpanId  SpanId
+	Sampled bool
+}
+
+func (this SpanContext) IsValid() bool {
+	return this.TraceId != TraceId{} && this.SpanId != SpanId{}
+}
+
+// Traceparent formats the W3C traceparent header.
+func (this SpanContext) Traceparent() string {
+	flags := "00"
+	if this.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(this.TraceId[:]) + "-" + hex.EncodeToString(this.SpanId[:]) + "-" + flags
+}
+
+// ParseTraceparent parses the W3C traceparent header.
+func ParseTraceparent(header string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceId[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanId[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+type attribute struct {
+	key   string
+	value any
+}
+
+type Span struct {
+	sc        SpanContext
+	parent    SpanId
+	name      string
+	kind      int
+	startTime time.Time
+	endTime   time.Time
+	attrs     []attribute
+	status    int
+	message   string
+	ended     bool
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// SpanFromContext returns the current span, nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithSpan returns a context holding span, which becomes the parent
+// of the spans started from it.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// ContextWithRemoteParent makes sc, usually parsed from an incoming
+// traceparent header, the parent of the next span started from ctx.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Start starts a span which is a child of the span in ctx. It returns a nil
+// span when tracing is disabled; all the span methods accept a nil span.
+func Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if gTracer == nil {
+		return ctx, nil
+	}
+	var parent SpanContext
+	if span := SpanFromContext(ctx); span != nil {
+		parent = span.sc
+	} else if sc, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		parent = sc
+	}
+	span := StartWithParent(parent, name, kind)
+	return ContextWithSpan(ctx, span), span
+}
+
+// StartWithParent starts a span with an explicit parent. An invalid parent
+// starts a new trace.
+func StartWithParent(parent SpanContext, name string, kind int) *Span {
+	t := gTracer
+	if t == nil {
+		return nil
+	}
+	span := &Span{
+		name:      name,
+		kind:      kind,
+		startTime: time.Now(),
+	}
+	rand.Read(span.sc.SpanId[:])
+	if parent.IsValid() {
+		span.sc.TraceId = parent.TraceId
+		span.sc.Sampled = parent.Sampled
+		span.parent = parent.SpanId
+	} else {
+		rand.Read(span.sc.TraceId[:])
+		span.sc.Sampled = t.sample(span.sc.TraceId)
+	}
+	return span
+}
+
+func (this *Span) SpanContext() SpanContext {
+	if this == nil {
+		return SpanContext{}
+	}
+	return this.sc
+}
+
+func (this *Span) SetAttr(key string, value any) {
+	if this == nil || this.ended {
+		return
+	}
+	this.attrs = append(this.attrs, attribute{key: key, value: value})
+}
+
+func (this *Span) SetError(err error) {
+	if this == nil || this.ended || err == nil {
+		return
+	}
+	this.status = StatusCodeError
+	this.message = err.Error()
+}
+
+func (this *Span) End() {
+	if this == nil || this.ended {
+		return
+	}
+	this.ended = true
+	this.endTime = time.Now()
+	if t := gTracer; t != nil && this.sc.Sampled {
+		t.export(this)
+	}
+}
+
+// sampleThreshold compares the low bytes of the trace id, so that the
+// decision is the same wherever the trace id is seen.
+func sampleThreshold(ratio float64) uint64 {
+	if ratio >= 1 {
+		return math.MaxUint64
+	}
+	if ratio <= 0 {
+		return 0
+	}
+	return uint64(ratio * math.MaxUint64)
+}
+
+func traceIdLow(id TraceId) uint64 {
+	var v uint64
+	for _, b := range id[8:] {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
@@ -0,0 +1,91 @@
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || !sc.Sampled {
+		t.Fatal("parse failed")
+	}
+	if s := sc.Traceparent(); s != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("format: %s", s)
+	}
+	for _, header := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+	} {
+		if _, ok := ParseTraceparent(header); ok {
+			t.Errorf("%q should be invalid", header)
+		}
+	}
+}
+
+func TestExportToCollector(t *testing.T) {
+	var mutex sync.Mutex
+	var spans []otlpSpan
+	var service string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req otlpRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		for _, rs := range req.ResourceSpans {
+			service = rs.Resource.Attributes[0].Value["stringValue"].(string)
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+		mutex.Unlock()
+	}))
+	defer collector.Close()
+
+	Init(&Config{Endpoint: collector.URL, ServiceName: "test", SampleRatio: 1})
+	defer Close()
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := Start(ContextWithRemoteParent(context.Background(), remote), "ssr.request", SpanKindServer)
+	_, child := Start(ctx, "vm.execute", SpanKindInternal)
+	child.SetAttr("vm.worker_id", int64(3))
+	child.End()
+	root.SetError(io.EOF)
+	root.End()
+
+	// unsampled traces are not exported
+	unsampled, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	StartWithParent(unsampled, "dropped", SpanKindClient).End()
+
+	Flush()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if service != "test" || len(spans) != 2 {
+		t.Fatalf("service: %s, spans: %+v", service, spans)
+	}
+	if spans[0].Name != "vm.execute" || spans[0].ParentSpanId != hex.EncodeToString(root.sc.SpanId[:]) ||
+		spans[0].Attributes[0].Value["intValue"] != "3" {
+		t.Errorf("child: %+v", spans[0])
+	}
+	if spans[1].TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || spans[1].ParentSpanId != "00f067aa0ba902b7" ||
+		spans[1].Kind != SpanKindServer || spans[1].Status == nil || spans[1].Status.Code != StatusCodeError {
+		t.Errorf("root: %+v", spans[1])
+	}
+}
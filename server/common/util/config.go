@@ -20,6 +20,7 @@ func NewConfig(defaultPath string, v interface{}) (bool, string) {
 	shardVal := parser.String("shard", "", "Input server shard")
 	assetVal := parser.String("asset", "", "Input asset dir")
 	hostPortVal := parser.String("host", "", "Input host id and port")
+	prerenderVal := parser.String("prerender", "", "Input route list to pre-render")
 	parser.Parse(os.Args[1:])
 
 	bRet := false
@@ -80,6 +81,13 @@ func NewConfig(defaultPath string, v interface{}) (bool, string) {
 				}
 			}
 
+			if *prerenderVal != "" {
+				vPrerender := val.FieldByName("Prerender")
+				if vPrerender.Kind() == reflect.String {
+					vPrerender.SetString(*prerenderVal)
+				}
+			}
+
 			vHost := val.FieldByName("Host")
 			if vHost.Kind() == reflect.String {
 				if *hostPortVal != "" {
@@ -2,32 +2,114 @@ package util
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
-func GraceHttpServe(addr string, handler http.Handler) error {
+const (
+	UnixAddrPrefix           = "unix:"
+	CertReloadInterval       = 10
+	DefaultReadHeaderTimeout = 10
+)
+
+// HttpConfig configures the listeners of GraceHttpServe. Timeouts are in
+// seconds, 0 means no timeout.
+type HttpConfig struct {
+	Listen            []string `toml:"listen"`
+	TlsCert           string   `toml:"tls_cert"`
+	TlsKey            string   `toml:"tls_key"`
+	H2c               bool     `toml:"h2c"`
+	ReadHeaderTimeout int      `toml:"read_header_timeout"`
+	ReadTimeout       int      `toml:"read_timeout"`
+	WriteTimeout      int      `toml:"write_timeout"`
+	IdleTimeout       int      `toml:"idle_timeout"`
+}
+
+// GraceHttpServe serves handler on every address until SIGINT or SIGTERM.
+// An address is either "host:port" or "unix:/path/to/socket". When a
+// certificate is configured, tcp addresses are served with TLS and unix
+// sockets stay in cleartext.
+func GraceHttpServe(addrs []string, c *HttpConfig, handler http.Handler) error {
+	if len(addrs) == 0 {
+		return errors.New("no listen address")
+	}
+
+	readHeaderTimeout := c.ReadHeaderTimeout
+	if readHeaderTimeout <= 0 {
+		readHeaderTimeout = DefaultReadHeaderTimeout
+	}
 	srv := &http.Server{
-		Addr:    addr,
-		Handler: handler,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(readHeaderTimeout) * time.Second,
+		ReadTimeout:       time.Duration(c.ReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(c.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(c.IdleTimeout) * time.Second,
+	}
+	if c.H2c {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		srv.Protocols = protocols
 	}
 
-	var serveErr error
-	serveEnd := make(chan struct{})
+	var certLoader *certReloader
+	if c.TlsCert != "" || c.TlsKey != "" {
+		var err error
+		certLoader, err = newCertReloader(c.TlsCert, c.TlsKey)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certLoader.getCertificate,
+		}
+	}
 
-	// Initializing the server in a goroutine so that
-	// it won't block the graceful shutdown handling below
-	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			tlog.Error("http server start error:", err)
-			serveErr = err
-			close(serveEnd)
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		l, err := listen(addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
 		}
-	}()
+		listeners = append(listeners, l)
+	}
+
+	if certLoader != nil {
+		go certLoader.run()
+		defer certLoader.stop()
+	}
+
+	serveErr := make(chan error, len(listeners))
+	// Initializing the server in goroutines so that
+	// they won't block the graceful shutdown handling below
+	for i, l := range listeners {
+		bTls := certLoader != nil && !isUnixAddr(addrs[i])
+		go func() {
+			var err error
+			if bTls {
+				err = srv.ServeTLS(l, "", "")
+			} else {
+				err = srv.Serve(l)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				tlog.Error("http server start error:", err)
+				serveErr <- err
+			}
+		}()
+	}
 
 	quit := make(chan os.Signal, 1)
 	// kill (no param) default send syscall.SIGTERM
@@ -36,11 +118,12 @@ func GraceHttpServe(addr string, handler http.Handler) error {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
 	select {
-	case <-serveEnd:
-		return serveErr
+	case err := <-serveErr:
+		srv.Close()
+		return err
 	case <-quit:
 		tlog.Info("shutting down http server...")
-		// The context is used to inform the server it has 5 seconds to finish
+		// The context is used to inform the server it has 30 seconds to finish
 		// the request it is currently handling
 		timeoutCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
@@ -50,3 +133,108 @@ func GraceHttpServe(addr string, handler http.Handler) error {
 		return nil
 	}
 }
+
+func isUnixAddr(addr string) bool {
+	return strings.HasPrefix(addr, UnixAddrPrefix)
+}
+
+func listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, UnixAddrPrefix); ok {
+		// remove the socket left by a previous run
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		os.Chmod(path, 0666)
+		return l, nil
+	}
+	return net.Listen("tcp", addr)
+}
+
+// certReloader reloads the certificate when the cert or key file changes,
+// so renewed certificates are picked up without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+	modTime  time.Time
+	stopCh   chan struct{}
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("both tls_cert and tls_key must be set")
+	}
+	this := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		stopCh:   make(chan struct{}),
+	}
+	if err := this.load(); err != nil {
+		return nil, err
+	}
+	return this, nil
+}
+
+func (this *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return this.cert.Load(), nil
+}
+
+func (this *certReloader) load() error {
+	modTime, err := this.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(this.certFile, this.keyFile)
+	if err != nil {
+		return err
+	}
+	this.cert.Store(&cert)
+	this.modTime = modTime
+	return nil
+}
+
+func (this *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{this.certFile, this.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (this *certReloader) run() {
+	ticker := time.NewTicker(CertReloadInterval * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.stopCh:
+			return
+		case <-ticker.C:
+			modTime, err := this.latestModTime()
+			if err != nil || modTime.Equal(this.modTime) {
+				continue
+			}
+			// the cert and key may be written one after the other, keep
+			// the old certificate until the pair matches again
+			if err = this.load(); err != nil {
+				this.modTime = modTime
+				tlog.Errorf("tls certificate reload error: %v", err)
+			} else {
+				tlog.Infof("tls certificate reloaded: %s", this.certFile)
+			}
+		}
+	}
+}
+
+func (this *certReloader) stop() {
+	close(this.stopCh)
+}
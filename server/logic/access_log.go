@@ -0,0 +1,123 @@
+package logic
+
+import (
+	"context"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/util"
+	"net/http"
+	"time"
+)
+
+const (
+	OutcomeStatic      = "static"
+	OutcomePrerendered = "prerendered"
+	OutcomeProxy       = "proxy"
+)
+
+// accessRecord collects what is written to the access log for a request.
+// The ssr handler finds it in the request context and fills in the render
+// timings.
+type accessRecord struct {
+	beginTime    time.Time
+	status       int
+	bytes        int64
+	outcome      string
+	render       *Render
+	assembleTime time.Duration
+}
+
+type accessRecordKey struct{}
+
+func getAccessRecord(request *http.Request) *accessRecord {
+	rec, _ := request.Context().Value(accessRecordKey{}).(*accessRecord)
+	return rec
+}
+
+func setAccessOutcome(request *http.Request, outcome string) {
+	if rec := getAccessRecord(request); rec != nil {
+		rec.outcome = outcome
+	}
+}
+
+func setAccessRender(request *http.Request, render *Render, assembleTime time.Duration) {
+	if rec := getAccessRecord(request); rec != nil {
+		rec.render = render
+		rec.assembleTime = assembleTime
+	}
+}
+
+func withAccessLog(writer http.ResponseWriter, request *http.Request, next func(http.ResponseWriter, *http.Request)) {
+	if !tlog.IsAccessEnabled() {
+		next(writer, request)
+		return
+	}
+
+	rec := &accessRecord{beginTime: time.Now()}
+	aw := &accessWriter{ResponseWriter: writer, rec: rec}
+	next(aw, request.WithContext(context.WithValue(request.Context(), accessRecordKey{}, rec)))
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	writeAccessLog(writer, request, rec)
+}
+
+func writeAccessLog(writer http.ResponseWriter, request *http.Request, rec *accessRecord) {
+	fields := []tlog.Field{
+		tlog.Kv("method", request.Method),
+		tlog.Kv("url", request.URL.RequestURI()),
+		tlog.Kv("status", rec.status),
+		tlog.Kv("bytes", rec.bytes),
+		tlog.Kv("ip", util.GetClientIP(request)),
+		tlog.Kv("user_agent", request.UserAgent()),
+		tlog.Kv("request_id", writer.Header().Get("X-Request-Id")),
+		tlog.Kv("outcome", rec.outcome),
+		tlog.Kv("elapse_ms", toMilliseconds(time.Since(rec.beginTime))),
+	}
+	if render := rec.render; render != nil {
+		fields = append(fields,
+			tlog.Kv("render_id", render.renderId),
+			tlog.Kv("worker_id", render.workerId),
+			tlog.Kv("vm_wait_ms", toMilliseconds(render.acquireWait)),
+			tlog.Kv("js_ms", toMilliseconds(render.jsTime)),
+			tlog.Kv("xhr_count", render.xhrCount),
+			tlog.Kv("xhr_ms", toMilliseconds(render.xhrTime)),
+			tlog.Kv("assemble_ms", toMilliseconds(rec.assembleTime)),
+		)
+	}
+	tlog.Access(fields...)
+}
+
+func toMilliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+type accessWriter struct {
+	http.ResponseWriter
+	rec *accessRecord
+}
+
+func (this *accessWriter) WriteHeader(status int) {
+	if this.rec.status == 0 {
+		this.rec.status = status
+	}
+	this.ResponseWriter.WriteHeader(status)
+}
+
+func (this *accessWriter) Write(b []byte) (int, error) {
+	if this.rec.status == 0 {
+		this.rec.status = http.StatusOK
+	}
+	n, err := this.ResponseWriter.Write(b)
+	this.rec.bytes += int64(n)
+	return n, err
+}
+
+func (this *accessWriter) Flush() {
+	if flusher, ok := this.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (this *accessWriter) Unwrap() http.ResponseWriter {
+	return this.ResponseWriter
+}
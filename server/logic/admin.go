@@ -0,0 +1,173 @@
+package logic
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"github.com/lizc2003/vue-ssr-v8go/server/v8"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type AdminConfig struct {
+	Host  string `toml:"host"`
+	Token string `toml:"token"`
+}
+
+func runAdminServer(c *AdminConfig) {
+	if c.Host == "" {
+		return
+	}
+	if c.Token == "" {
+		tlog.Error("admin server is disabled: admin.token is empty")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /reload", handleAdminReload)
+	mux.HandleFunc("GET /vm/workers", handleAdminWorkers)
+	mux.HandleFunc("POST /vm/workers/{id}/heapsnapshot", handleAdminHeapSnapshot)
+	mux.HandleFunc("POST /vm/workers/{id}/gc", handleAdminWorkerGc)
+	mux.HandleFunc("POST /vm/gc", handleAdminGc)
+	mux.HandleFunc("POST /vm/recycle", handleAdminRecycle)
+	mux.HandleFunc("POST /vm/max_instances", handleAdminMaxInstances)
+	mux.HandleFunc("POST /isr/purge", handleAdminIsrPurge)
+	mux.HandleFunc("POST /xhr_cache/purge", handleAdminXhrCachePurge)
+
+	tlog.Infof("admin server listen on %s", c.Host)
+	err := http.ListenAndServe(c.Host, adminAuth(c.Token, mux))
+	if err != nil {
+		tlog.Error("admin server start error:", err)
+	}
+}
+
+func adminAuth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		auth := request.Header.Get("Authorization")
+		reqToken, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(reqToken), []byte(token)) != 1 {
+			writeAdminResult(writer, http.StatusUnauthorized, nil, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func handleAdminReload(writer http.ResponseWriter, request *http.Request) {
+	err := ReloadBundle()
+	if err != nil {
+		tlog.Error("bundle reload refused: " + err.Error())
+		writeAdminResult(writer, http.StatusInternalServerError, nil, err)
+		return
+	}
+	writeAdminResult(writer, http.StatusOK, nil, nil)
+}
+
+func handleAdminWorkers(writer http.ResponseWriter, request *http.Request) {
+	vmMgr := ThisServer.VmMgr
+	writeAdminResult(writer, http.StatusOK, map[string]any{
+		"current_instances": vmMgr.GetCurrentInstances(),
+		"max_instances":     vmMgr.GetMaxInstances(),
+		"workers":           vmMgr.Workers(),
+	}, nil)
+}
+
+func handleAdminHeapSnapshot(writer http.ResponseWriter, request *http.Request) {
+	workerId, err := strconv.ParseInt(request.PathValue("id"), 10, 64)
+	if err != nil {
+		writeAdminResult(writer, http.StatusBadRequest, nil, err)
+		return
+	}
+	fName, err := ThisServer.VmMgr.DumpHeap(workerId)
+	if err != nil {
+		writeAdminResult(writer, adminErrorStatus(err), nil, err)
+		return
+	}
+	writeAdminResult(writer, http.StatusOK, map[string]any{"file": fName}, nil)
+}
+
+func handleAdminWorkerGc(writer http.ResponseWriter, request *http.Request) {
+	workerId, err := strconv.ParseInt(request.PathValue("id"), 10, 64)
+	if err != nil {
+		writeAdminResult(writer, http.StatusBadRequest, nil, err)
+		return
+	}
+	err = ThisServer.VmMgr.FullGC(workerId)
+	if err != nil {
+		writeAdminResult(writer, adminErrorStatus(err), nil, err)
+		return
+	}
+	writeAdminResult(writer, http.StatusOK, nil, nil)
+}
+
+func handleAdminGc(writer http.ResponseWriter, request *http.Request) {
+	ids := ThisServer.VmMgr.FullGCAll()
+	writeAdminResult(writer, http.StatusOK, map[string]any{"workers": ids}, nil)
+}
+
+func handleAdminRecycle(writer http.ResponseWriter, request *http.Request) {
+	ThisServer.VmMgr.Recycle()
+	writeAdminResult(writer, http.StatusOK, nil, nil)
+}
+
+func handleAdminMaxInstances(writer http.ResponseWriter, request *http.Request) {
+	var req struct {
+		MaxInstances int32 `json:"max_instances"`
+	}
+	err := json.NewDecoder(request.Body).Decode(&req)
+	if err == nil {
+		err = ThisServer.VmMgr.SetMaxInstances(req.MaxInstances)
+	}
+	if err != nil {
+		writeAdminResult(writer, http.StatusBadRequest, nil, err)
+		return
+	}
+	writeAdminResult(writer, http.StatusOK, map[string]any{"max_instances": req.MaxInstances}, nil)
+}
+
+func handleAdminIsrPurge(writer http.ResponseWriter, request *http.Request) {
+	var req struct {
+		Paths []string `json:"paths"`
+	}
+	err := json.NewDecoder(request.Body).Decode(&req)
+	if err == nil && len(req.Paths) == 0 {
+		err = errors.New("paths is empty")
+	}
+	if err != nil {
+		writeAdminResult(writer, http.StatusBadRequest, nil, err)
+		return
+	}
+	writeAdminResult(writer, http.StatusOK, ThisServer.Isr.Purge(req.Paths), nil)
+}
+
+func handleAdminXhrCachePurge(writer http.ResponseWriter, request *http.Request) {
+	bEnabled := ThisServer.VmMgr.PurgeXhrCache()
+	writeAdminResult(writer, http.StatusOK, map[string]any{"enabled": bEnabled}, nil)
+}
+
+func adminErrorStatus(err error) int {
+	switch err {
+	case v8.ErrorVmNotFound:
+		return http.StatusNotFound
+	case v8.ErrorVmBusy:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeAdminResult(writer http.ResponseWriter, statusCode int, data any, err error) {
+	ret := map[string]any{"ok": err == nil}
+	if err != nil {
+		ret["error"] = err.Error()
+	}
+	if data != nil {
+		ret["data"] = data
+	}
+	body, _ := json.Marshal(ret)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(statusCode)
+	writer.Write(body)
+}
@@ -0,0 +1,99 @@
+package logic
+
+import (
+	"encoding/hex"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+type CacheControlRule struct {
+	Path  string `toml:"path"`
+	Value string `toml:"value"`
+}
+
+type CacheControlConfig struct {
+	Rules []CacheControlRule `toml:"rule"`
+}
+
+type CacheControl struct {
+	rules []CacheControlRule
+}
+
+func NewCacheControl(c *CacheControlConfig) *CacheControl {
+	rules := make([]CacheControlRule, 0, len(c.Rules))
+	for _, rule := range c.Rules {
+		if rule.Path == "" || rule.Value == "" {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].Path) > len(rules[j].Path)
+	})
+	return &CacheControl{rules: rules}
+}
+
+func (this *CacheControl) Match(urlPath string) string {
+	for i := range this.rules {
+		if MatchPath(urlPath, this.rules[i].Path) {
+			return this.rules[i].Value
+		}
+	}
+	return ""
+}
+
+// setCacheControl sets the Cache-Control of the matching rule, unless the
+// response already has one.
+func setCacheControl(writer http.ResponseWriter, urlPath string) {
+	if ThisServer.CacheControl == nil || writer.Header().Get("Cache-Control") != "" {
+		return
+	}
+	if value := ThisServer.CacheControl.Match(urlPath); value != "" {
+		writer.Header().Set("Cache-Control", value)
+	}
+}
+
+func htmlEtag(html string) string {
+	h := fnv.New64a()
+	h.Write([]byte(html))
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// etagMatch reports whether the If-None-Match header matches etag, using
+// the weak comparison as required for GET.
+func etagMatch(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, item := range strings.Split(ifNoneMatch, ",") {
+		item = strings.TrimSpace(item)
+		if item == "*" || strings.TrimPrefix(item, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// noDirFileSystem hides directories, so http.FileServer never lists them.
+type noDirFileSystem struct {
+	http.FileSystem
+}
+
+func (this noDirFileSystem) Open(name string) (http.File, error) {
+	f, err := this.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err != nil || fi.IsDir() {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+	return f, nil
+}
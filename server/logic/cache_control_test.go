@@ -0,0 +1,39 @@
+package logic
+
+import "testing"
+
+func TestCacheControlMatch(t *testing.T) {
+	c := NewCacheControl(&CacheControlConfig{Rules: []CacheControlRule{
+		{Path: "/", Value: "no-cache"},
+		{Path: "/assets/", Value: "immutable"},
+		{Path: "/empty/"},
+	}})
+	if v := c.Match("/assets/app.123.js"); v != "immutable" {
+		t.Errorf("assets: %s", v)
+	}
+	if v := c.Match("/empty/a"); v != "no-cache" {
+		t.Errorf("empty: %s", v)
+	}
+	if NewCacheControl(&CacheControlConfig{}) != nil {
+		t.Error("no rules should give nil")
+	}
+}
+
+func TestEtagMatch(t *testing.T) {
+	etag := htmlEtag("<html></html>")
+	if etag != htmlEtag("<html></html>") || etag == htmlEtag("<html> </html>") {
+		t.Fatal("etag is not stable")
+	}
+	for ifNoneMatch, expected := range map[string]bool{
+		"":             false,
+		etag:           true,
+		"W/" + etag:    true,
+		`"x", ` + etag: true,
+		"*":            true,
+		`"x"`:          false,
+	} {
+		if etagMatch(ifNoneMatch, etag) != expected {
+			t.Errorf("%q: expected %v", ifNoneMatch, expected)
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package logic
 
+import (
+	"strconv"
+	"time"
+)
+
 func SendMessageCallback(mtype int64, param1 int64, param2 string, param3 string, param4 string, param5 string) {
 	switch mtype {
 	case 10:
@@ -10,14 +15,23 @@ func SendMessageCallback(mtype int64, param1 int64, param2 string, param3 string
 			Modules: param5,
 		}
 
-		bOK := true
-		if result.Html == "" {
-			bOK = false
-			result.Html = "no render result"
-		}
-		ThisServer.RenderMgr.SendResult(param1, bOK, result)
+		ThisServer.RenderMgr.SendResult(param1, true, result)
 	case 11:
 		ThisServer.RenderMgr.SendResult(param1, false,
 			RenderResult{Html: param2})
+	case 12:
+		ThisServer.RenderMgr.SendChunk(param1, param2)
+	case 13:
+		ThisServer.RenderMgr.SendResponse(param1, parseRenderResponse(param1, param2))
+	case 14:
+		if isForwardSetCookie(param2, ThisServer.ForwardSetCookies) {
+			ThisServer.RenderMgr.AddSetCookie(param1, param2)
+		}
+	case 15:
+		elapse, _ := strconv.ParseInt(param2, 10, 64)
+		ThisServer.RenderMgr.AddXhr(param1, time.Duration(elapse))
+	case 16:
+		elapse, _ := strconv.ParseInt(param2, 10, 64)
+		ThisServer.RenderMgr.AddJsTime(param1, time.Duration(elapse))
 	}
 }
@@ -21,8 +21,10 @@ var (
 	ErrorPageRedirect  = errors.New("page redirect")
 	ErrorSsrOff        = errors.New("ssr off")
 	ErrorRenderTimeout = errors.New("render timeout")
+	ErrorRenderCancel  = errors.New("render canceled")
+	ErrorSsrBypass     = errors.New("ssr bypassed")
 
-	ForwardHeaders = []string{
+	DefaultForwardHeaders = []string{
 		"Cookie",
 		"User-Agent",
 		"X-Forwarded-For",
@@ -81,6 +83,15 @@ func getResponseHeaders(url string) map[string]string {
 	return headers
 }
 
+func isStreamPath(url string) bool {
+	for _, p := range ThisServer.StreamPaths {
+		if MatchPath(url, p) {
+			return true
+		}
+	}
+	return false
+}
+
 func MatchPath(path, pattern string) bool {
 	if strings.HasPrefix(path, pattern) {
 		return true
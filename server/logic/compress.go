@@ -0,0 +1,325 @@
+package logic
+
+import (
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	EncodingBr   = "br"
+	EncodingGzip = "gzip"
+
+	DefaultCompressMinSize = 1024
+	DefaultGzipLevel       = 6
+	DefaultBrLevel         = 4
+)
+
+var DefaultCompressTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"image/svg+xml",
+}
+
+type CompressConfig struct {
+	Enable    bool     `toml:"enable"`
+	MinSize   int      `toml:"min_size"`
+	Types     []string `toml:"types"`
+	GzipLevel int      `toml:"gzip_level"`
+	BrLevel   int      `toml:"br_level"`
+}
+
+type Compressor struct {
+	minSize  int
+	types    map[string]bool
+	gzipPool sync.Pool
+	brPool   sync.Pool
+}
+
+func NewCompressor(c *CompressConfig) *Compressor {
+	if !c.Enable {
+		return nil
+	}
+
+	minSize := c.MinSize
+	if minSize <= 0 {
+		minSize = DefaultCompressMinSize
+	}
+	types := c.Types
+	if len(types) == 0 {
+		types = DefaultCompressTypes
+	}
+	gzipLevel := c.GzipLevel
+	if gzipLevel < gzip.BestSpeed || gzipLevel > gzip.BestCompression {
+		gzipLevel = DefaultGzipLevel
+	}
+	brLevel := c.BrLevel
+	if brLevel < brotli.BestSpeed || brLevel > brotli.BestCompression {
+		brLevel = DefaultBrLevel
+	}
+
+	this := &Compressor{
+		minSize: minSize,
+		types:   make(map[string]bool, len(types)),
+	}
+	for _, t := range types {
+		this.types[strings.ToLower(strings.TrimSpace(t))] = true
+	}
+	this.gzipPool.New = func() any {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzipLevel)
+		return w
+	}
+	this.brPool.New = func() any {
+		return brotli.NewWriterLevel(io.Discard, brLevel)
+	}
+	return this
+}
+
+func (this *Compressor) isCompressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return this.types[strings.ToLower(strings.TrimSpace(mediaType))]
+}
+
+// ServeStatic serves the precompressed .br or .gz sibling of a public file
+// when the client accepts it. It returns false when there is none.
+func (this *Compressor) ServeStatic(writer http.ResponseWriter, request *http.Request, fileName string) bool {
+	contentType := mime.TypeByExtension(path.Ext(fileName))
+	if !this.isCompressible(contentType) {
+		return false
+	}
+	fi, err := os.Stat(fileName)
+	if err != nil || fi.IsDir() || fi.Size() < int64(this.minSize) {
+		return false
+	}
+	writer.Header().Add("Vary", "Accept-Encoding")
+
+	for _, encoding := range acceptEncodings(request.Header.Get("Accept-Encoding")) {
+		ext := ".gz"
+		if encoding == EncodingBr {
+			ext = ".br"
+		}
+		f, err := os.Open(fileName + ext)
+		if err != nil {
+			continue
+		}
+		cfi, err := f.Stat()
+		if err != nil || cfi.IsDir() {
+			f.Close()
+			continue
+		}
+		writer.Header().Set("Content-Type", contentType)
+		writer.Header().Set("Content-Encoding", encoding)
+		http.ServeContent(writer, request, fileName, cfi.ModTime(), f)
+		f.Close()
+		return true
+	}
+	return false
+}
+
+// Wrap compresses the response of next on the fly when the client accepts
+// it and the content type and size qualify.
+func (this *Compressor) Wrap(writer http.ResponseWriter, request *http.Request, next func(http.ResponseWriter)) {
+	encodings := acceptEncodings(request.Header.Get("Accept-Encoding"))
+	if len(encodings) == 0 || request.Method == http.MethodHead {
+		// still tell caches that the response depends on it
+		next(&varyWriter{ResponseWriter: writer, compressor: this})
+		return
+	}
+
+	cw := &compressWriter{
+		ResponseWriter: writer,
+		compressor:     this,
+		encoding:       encodings[0],
+	}
+	next(cw)
+	cw.close()
+}
+
+// acceptEncodings returns the supported encodings accepted by the client,
+// preferred first.
+func acceptEncodings(acceptEncoding string) []string {
+	if acceptEncoding == "" {
+		return nil
+	}
+	var br, gz, star float64 = -1, -1, -1
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(item, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case EncodingBr:
+			br = q
+		case EncodingGzip, "x-gzip":
+			gz = q
+		case "*":
+			star = q
+		}
+	}
+	if br < 0 {
+		br = star
+	}
+	if gz < 0 {
+		gz = star
+	}
+
+	var ret []string
+	if br > 0 && br >= gz {
+		ret = append(ret, EncodingBr)
+	}
+	if gz > 0 {
+		ret = append(ret, EncodingGzip)
+	}
+	if br > 0 && br < gz {
+		ret = append(ret, EncodingBr)
+	}
+	return ret
+}
+
+type varyWriter struct {
+	http.ResponseWriter
+	compressor *Compressor
+}
+
+func (this *varyWriter) WriteHeader(status int) {
+	if this.compressor.isCompressible(this.Header().Get("Content-Type")) {
+		this.Header().Add("Vary", "Accept-Encoding")
+	}
+	this.ResponseWriter.WriteHeader(status)
+}
+
+func (this *varyWriter) Write(b []byte) (int, error) {
+	return this.ResponseWriter.Write(b)
+}
+
+func (this *varyWriter) Flush() {
+	if flusher, ok := this.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (this *varyWriter) Unwrap() http.ResponseWriter {
+	return this.ResponseWriter
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	compressor  *Compressor
+	encoding    string
+	encoder     io.WriteCloser
+	wroteHeader bool
+}
+
+func (this *compressWriter) WriteHeader(status int) {
+	if this.wroteHeader {
+		return
+	}
+	this.wroteHeader = true
+
+	header := this.Header()
+	if this.compressor.isCompressible(header.Get("Content-Type")) {
+		header.Add("Vary", "Accept-Encoding")
+		if this.shouldCompress(status, header) {
+			header.Del("Content-Length")
+			header.Del("Accept-Ranges")
+			header.Set("Content-Encoding", this.encoding)
+			if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				header.Set("ETag", "W/"+etag)
+			}
+			this.encoder = this.compressor.getEncoder(this.encoding, this.ResponseWriter)
+		}
+	}
+	this.ResponseWriter.WriteHeader(status)
+}
+
+func (this *compressWriter) shouldCompress(status int, header http.Header) bool {
+	if status != http.StatusOK && status < http.StatusBadRequest {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	// a response without length is streamed and always compressed
+	if cl := header.Get("Content-Length"); cl != "" {
+		n, err := strconv.Atoi(cl)
+		if err == nil && n < this.compressor.minSize {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *compressWriter) Write(b []byte) (int, error) {
+	if !this.wroteHeader {
+		if this.Header().Get("Content-Type") == "" {
+			this.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		this.WriteHeader(http.StatusOK)
+	}
+	if this.encoder != nil {
+		return this.encoder.Write(b)
+	}
+	return this.ResponseWriter.Write(b)
+}
+
+func (this *compressWriter) Flush() {
+	if this.encoder != nil {
+		switch e := this.encoder.(type) {
+		case *gzip.Writer:
+			e.Flush()
+		case *brotli.Writer:
+			e.Flush()
+		}
+	}
+	if flusher, ok := this.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (this *compressWriter) Unwrap() http.ResponseWriter {
+	return this.ResponseWriter
+}
+
+func (this *compressWriter) close() {
+	if this.encoder != nil {
+		this.encoder.Close()
+		this.compressor.putEncoder(this.encoder)
+		this.encoder = nil
+	}
+}
+
+func (this *Compressor) getEncoder(encoding string, w io.Writer) io.WriteCloser {
+	if encoding == EncodingBr {
+		e := this.brPool.Get().(*brotli.Writer)
+		e.Reset(w)
+		return e
+	}
+	e := this.gzipPool.Get().(*gzip.Writer)
+	e.Reset(w)
+	return e
+}
+
+func (this *Compressor) putEncoder(e io.WriteCloser) {
+	switch e := e.(type) {
+	case *gzip.Writer:
+		e.Reset(io.Discard)
+		this.gzipPool.Put(e)
+	case *brotli.Writer:
+		e.Reset(io.Discard)
+		this.brPool.Put(e)
+	}
+}
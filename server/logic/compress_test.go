@@ -0,0 +1,61 @@
+package logic
+
+import (
+	"compress/gzip"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/util"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAcceptEncodings(t *testing.T) {
+	cases := map[string][]string{
+		"":                      nil,
+		"gzip, deflate, br":     {EncodingBr, EncodingGzip},
+		"gzip;q=1, br;q=0.5":    {EncodingGzip, EncodingBr},
+		"br;q=0, gzip":          {EncodingGzip},
+		"*":                     {EncodingBr, EncodingGzip},
+		"identity, gzip;q=0":    nil,
+		"deflate, x-gzip;q=0.8": {EncodingGzip},
+	}
+	for ae, expected := range cases {
+		if got := acceptEncodings(ae); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%q: expected %v, got %v", ae, expected, got)
+		}
+	}
+}
+
+func TestCompressWriter(t *testing.T) {
+	compressor := NewCompressor(&CompressConfig{Enable: true, MinSize: 100})
+	html := strings.Repeat("<p>hello</p>", 50)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	compressor.Wrap(recorder, request, func(w http.ResponseWriter) {
+		util.WriteHtmlResponse(w, http.StatusOK, html, nil)
+	})
+	if recorder.Header().Get("Content-Encoding") != EncodingGzip || recorder.Header().Get("Content-Length") != "" {
+		t.Fatalf("headers: %v", recorder.Header())
+	}
+	r, err := gzip.NewReader(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(r)
+	if string(body) != html {
+		t.Errorf("body mismatch")
+	}
+
+	// below min size
+	recorder = httptest.NewRecorder()
+	compressor.Wrap(recorder, request, func(w http.ResponseWriter) {
+		util.WriteHtmlResponse(w, http.StatusOK, "<p>hi</p>", nil)
+	})
+	if recorder.Header().Get("Content-Encoding") != "" || recorder.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("small response: %v", recorder.Header())
+	}
+}
@@ -0,0 +1,190 @@
+package logic
+
+import (
+	"fmt"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/alarm"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"github.com/lizc2003/vue-ssr-v8go/server/v8"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	FallbackCsr   = "csr"
+	FallbackError = "error"
+
+	FallbackReasonSaturated = "saturated"
+	FallbackReasonError     = "error"
+	FallbackReasonTimeout   = "timeout"
+	FallbackReasonBreaker   = "breaker"
+
+	DefaultBreakerCooldown = 30 // seconds
+)
+
+// FallbackConfig decides what is served when ssr fails. "csr" (the default)
+// serves the untouched index.html with status 200 so that the client bundle
+// renders the page; "error" serves it with a 5xx status instead.
+type FallbackConfig struct {
+	OnSaturated      string `toml:"on_saturated"`
+	OnError          string `toml:"on_error"`
+	OnTimeout        string `toml:"on_timeout"`
+	BreakerThreshold int    `toml:"breaker_threshold"`
+	BreakerCooldown  int    `toml:"breaker_cooldown"`
+}
+
+type Fallback struct {
+	modes   map[string]string
+	breaker *circuitBreaker
+}
+
+func NewFallback(c *FallbackConfig) (*Fallback, error) {
+	modes := make(map[string]string)
+	for reason, mode := range map[string]string{
+		FallbackReasonSaturated: c.OnSaturated,
+		FallbackReasonError:     c.OnError,
+		FallbackReasonTimeout:   c.OnTimeout,
+	} {
+		if mode == "" {
+			mode = FallbackCsr
+		}
+		if mode != FallbackCsr && mode != FallbackError {
+			return nil, fmt.Errorf("invalid fallback mode for %s: %s", reason, mode)
+		}
+		modes[reason] = mode
+	}
+	modes[FallbackReasonBreaker] = FallbackCsr
+
+	ret := &Fallback{modes: modes}
+	if c.BreakerThreshold > 0 {
+		cooldown := c.BreakerCooldown
+		if cooldown <= 0 {
+			cooldown = DefaultBreakerCooldown
+		}
+		ret.breaker = newCircuitBreaker(c.BreakerThreshold, time.Duration(cooldown)*time.Second)
+	}
+	return ret, nil
+}
+
+// Allow reports whether ssr may be attempted; it is false while the circuit
+// breaker is open.
+func (this *Fallback) Allow() bool {
+	return this.breaker == nil || this.breaker.allow()
+}
+
+// Report feeds the outcome of an attempted render to the circuit breaker.
+func (this *Fallback) Report(err error) {
+	if this.breaker == nil {
+		return
+	}
+	if err == ErrorRenderCancel {
+		this.breaker.release()
+	} else if fallbackReason(err) != "" {
+		this.breaker.failure()
+	} else {
+		this.breaker.success()
+	}
+}
+
+// StatusCode returns the status to serve the index.html shell with.
+func (this *Fallback) StatusCode(reason string) int {
+	if this.modes[reason] == FallbackCsr {
+		return http.StatusOK
+	}
+	switch reason {
+	case FallbackReasonSaturated:
+		return http.StatusServiceUnavailable
+	case FallbackReasonTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func fallbackReason(err error) string {
+	switch err {
+	case nil, ErrorSsrOff, ErrorPageNotFound, ErrorPageRedirect, ErrorRenderCancel:
+		return ""
+	case v8.ErrorNoVm:
+		return FallbackReasonSaturated
+	case ErrorRenderTimeout:
+		return FallbackReasonTimeout
+	case ErrorSsrBypass:
+		return FallbackReasonBreaker
+	default:
+		return FallbackReasonError
+	}
+}
+
+////////////////////////////////////////////
+
+// circuitBreaker opens after threshold consecutive failures. Once the
+// cooldown has passed, a single probe render is let through: its success
+// closes the breaker and its failure opens it for another cooldown.
+type circuitBreaker struct {
+	mutex     sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+func (this *circuitBreaker) allow() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.failures < this.threshold {
+		return true
+	}
+	if this.probing || time.Now().Before(this.openUntil) {
+		return false
+	}
+	this.probing = true
+	return true
+}
+
+func (this *circuitBreaker) success() {
+	this.mutex.Lock()
+	bOpen := this.failures >= this.threshold
+	this.failures = 0
+	this.probing = false
+	this.mutex.Unlock()
+
+	if bOpen {
+		tlog.Info("ssr circuit breaker closed")
+	}
+}
+
+func (this *circuitBreaker) failure() {
+	this.mutex.Lock()
+	this.failures++
+	this.probing = false
+	bOpen := this.failures >= this.threshold
+	if bOpen {
+		this.openUntil = time.Now().Add(this.cooldown)
+	}
+	failures := this.failures
+	this.mutex.Unlock()
+
+	if bOpen {
+		errMsg := fmt.Sprintf("ssr circuit breaker open for %v after %d failures", this.cooldown, failures)
+		tlog.Error(errMsg)
+		if failures == this.threshold {
+			alarm.SendAlert(errMsg)
+		}
+	}
+}
+
+func (this *circuitBreaker) release() {
+	this.mutex.Lock()
+	this.probing = false
+	this.mutex.Unlock()
+}
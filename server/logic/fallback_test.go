@@ -0,0 +1,61 @@
+package logic
+
+import (
+	"errors"
+	"github.com/lizc2003/vue-ssr-v8go/server/v8"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	f, err := NewFallback(&FallbackConfig{BreakerThreshold: 2, BreakerCooldown: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.breaker.cooldown = 50 * time.Millisecond
+
+	f.Report(errors.New("boom"))
+	if !f.Allow() {
+		t.Fatal("breaker opened before threshold")
+	}
+	f.Report(ErrorRenderTimeout)
+	if f.Allow() {
+		t.Fatal("breaker should be open")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !f.Allow() {
+		t.Fatal("probe should be allowed after cooldown")
+	}
+	if f.Allow() {
+		t.Fatal("only one probe is allowed")
+	}
+	f.Report(ErrorRenderCancel)
+	if !f.Allow() {
+		t.Fatal("canceled probe should release the breaker")
+	}
+	f.Report(nil)
+	if !f.Allow() || !f.Allow() {
+		t.Fatal("breaker should be closed")
+	}
+}
+
+func TestFallbackStatusCode(t *testing.T) {
+	f, err := NewFallback(&FallbackConfig{OnTimeout: FallbackError})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := f.StatusCode(fallbackReason(ErrorRenderTimeout)); s != http.StatusGatewayTimeout {
+		t.Errorf("timeout status: %d", s)
+	}
+	if s := f.StatusCode(fallbackReason(v8.ErrorNoVm)); s != http.StatusOK {
+		t.Errorf("saturated status: %d", s)
+	}
+	if r := fallbackReason(ErrorPageNotFound); r != "" {
+		t.Errorf("404 is not a fallback: %s", r)
+	}
+	if _, err = NewFallback(&FallbackConfig{OnError: "spa"}); err == nil {
+		t.Error("invalid mode accepted")
+	}
+}
@@ -65,6 +65,16 @@ func NewIndexHtml(env string, publicDir string) (*IndexHtml, error) {
 }
 
 func (this *IndexHtml) GetIndexHtml(result RenderResult, renderErr error) (int, string, error) {
+	statusCode := http.StatusOK
+	if resp := result.Response; resp != nil {
+		if resp.Redirect != nil {
+			return resp.Redirect.Status, resp.Redirect.Url, ErrorPageRedirect
+		}
+		if resp.Status != 0 && renderErr == nil {
+			statusCode = resp.Status
+		}
+	}
+
 	err := renderErr
 	if err != nil {
 		errMsg := err.Error()
@@ -103,7 +113,48 @@ func (this *IndexHtml) GetIndexHtml(result RenderResult, renderErr error) (int,
 		}
 		indexHtml = strings.Replace(indexHtml, "<!--app-html-->", result.Html, 1)
 	}
-	return http.StatusOK, indexHtml, err
+	return statusCode, indexHtml, err
+}
+
+// GetStreamParts splits index.html at <!--app-html--> so the head can be
+// flushed before the app html is rendered.
+func (this *IndexHtml) GetStreamParts() (string, string) {
+	indexHtml, _, _ := this.getRawIndexHtml()
+	idx := strings.Index(indexHtml, "<!--app-html-->")
+	if idx < 0 {
+		return indexHtml, ""
+	}
+	return indexHtml[:idx], indexHtml[idx+len("<!--app-html-->"):]
+}
+
+// GetStreamTail fills the part of index.html following the app html. The head
+// is already sent, so preload links are appended just before </body>. When
+// the render failed, the partial app html is cleared and the client renders
+// the page itself.
+func (this *IndexHtml) GetStreamTail(tail string, result RenderResult, renderErr error) string {
+	if renderErr != nil {
+		return insertBeforeBody(tail, streamErrorScript)
+	}
+	if result.State != "" {
+		state := "window.__INITIAL_STATE__ = " + result.State
+		tail = strings.Replace(tail, "<!--app-state-->", state, 1)
+	}
+	if result.Modules != "" {
+		preloadLinks := this.getPreloadLinks(result.Modules)
+		if preloadLinks != "" {
+			tail = insertBeforeBody(tail, preloadLinks)
+		}
+	}
+	return tail
+}
+
+const streamErrorScript = `<script>window.__SSR_ERROR__ = true; document.getElementById('app').innerHTML = '';</script>`
+
+func insertBeforeBody(html string, s string) string {
+	if idx := strings.LastIndex(html, "</body>"); idx >= 0 {
+		return html[:idx] + s + html[idx:]
+	}
+	return html + s
 }
 
 func (this *IndexHtml) getRawIndexHtml() (string, int, int) {
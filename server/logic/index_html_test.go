@@ -0,0 +1,22 @@
+package logic
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGetStreamTail(t *testing.T) {
+	index := &IndexHtml{}
+	tail := `</div><script><!--app-state--></script></body></html>`
+
+	got := index.GetStreamTail(tail, RenderResult{State: `{"a":1}`}, nil)
+	if !strings.Contains(got, `window.__INITIAL_STATE__ = {"a":1}`) || strings.Contains(got, "__SSR_ERROR__") {
+		t.Errorf("unexpected tail: %s", got)
+	}
+
+	got = index.GetStreamTail(tail, RenderResult{}, errors.New("boom"))
+	if !strings.HasSuffix(got, streamErrorScript+"</body></html>") {
+		t.Errorf("failed stream without error marker: %s", got)
+	}
+}
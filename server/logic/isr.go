@@ -0,0 +1,188 @@
+package logic
+
+import (
+	"fmt"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type IsrRule struct {
+	Path       string `toml:"path"`
+	Revalidate int    `toml:"revalidate"`
+}
+
+type IsrConfig struct {
+	Rules []IsrRule `toml:"rule"`
+}
+
+type isrState struct {
+	refreshing bool
+	failTime   time.Time
+}
+
+// Isr regenerates the pages written by the prerender command in the
+// background once they are older than the revalidate interval of their rule.
+// The stale page keeps being served until the new one is written.
+type Isr struct {
+	mutex     sync.Mutex
+	publicDir string
+	rules     []IsrRule
+	states    map[string]*isrState
+}
+
+func NewIsr(c *IsrConfig, publicDir string) *Isr {
+	rules := make([]IsrRule, 0, len(c.Rules))
+	for _, rule := range c.Rules {
+		if rule.Revalidate > 0 {
+			rules = append(rules, rule)
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].Path) > len(rules[j].Path)
+	})
+
+	return &Isr{
+		publicDir: publicDir,
+		rules:     rules,
+		states:    make(map[string]*isrState),
+	}
+}
+
+func (this *Isr) MatchRule(urlPath string) *IsrRule {
+	for i := range this.rules {
+		if MatchPath(urlPath, this.rules[i].Path) {
+			return &this.rules[i]
+		}
+	}
+	return nil
+}
+
+// Check returns the cache state of a prerendered page and starts its
+// regeneration when it is stale.
+func (this *Isr) Check(urlPath string, fileName string) string {
+	urlPath = isrUrlPath(urlPath)
+	rule := this.MatchRule(urlPath)
+	if rule == nil {
+		return PageCacheHit
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return PageCacheHit
+	}
+
+	revalidate := time.Duration(rule.Revalidate) * time.Second
+	now := time.Now()
+	if now.Sub(info.ModTime()) < revalidate {
+		return PageCacheHit
+	}
+
+	this.mutex.Lock()
+	state, ok := this.states[urlPath]
+	if !ok {
+		state = &isrState{}
+		this.states[urlPath] = state
+	}
+	bRefresh := !state.refreshing && now.Sub(state.failTime) >= revalidate
+	if bRefresh {
+		state.refreshing = true
+	}
+	this.mutex.Unlock()
+
+	if bRefresh {
+		go func() {
+			err := this.regenerate(urlPath)
+			this.endRefresh(urlPath, err)
+		}()
+	}
+	return PageCacheStale
+}
+
+// Purge regenerates the pages at once. Only paths that were prerendered or
+// match a rule are accepted.
+func (this *Isr) Purge(paths []string) map[string]string {
+	ret := make(map[string]string, len(paths))
+	for _, p := range paths {
+		urlPath := isrUrlPath(p)
+		if urlPath == "" || strings.Contains(urlPath, "?") || strings.Contains(urlPath, "..") {
+			ret[p] = "invalid path"
+			continue
+		}
+		if this.MatchRule(urlPath) == nil && getPrerenderedFile(this.publicDir, urlPath) == "" {
+			ret[p] = "not a prerendered page"
+			continue
+		}
+
+		this.mutex.Lock()
+		state, ok := this.states[urlPath]
+		if !ok {
+			state = &isrState{}
+			this.states[urlPath] = state
+		}
+		bBusy := state.refreshing
+		state.refreshing = true
+		this.mutex.Unlock()
+		if bBusy {
+			ret[p] = "regenerating"
+			continue
+		}
+
+		err := this.regenerate(urlPath)
+		this.endRefresh(urlPath, err)
+		if err != nil {
+			ret[p] = err.Error()
+		} else {
+			ret[p] = "ok"
+		}
+	}
+	return ret
+}
+
+// regenerate renders the page again and replaces its file. A page that is
+// now a 404 or a redirect is removed so that ssr handles it.
+func (this *Isr) regenerate(urlPath string) error {
+	fileName := this.publicDir + urlPath + "/" + IndexName
+	beginTime := time.Now()
+	statusCode, indexHtml, err := renderStaticPage(urlPath)
+	if err == ErrorPageNotFound || err == ErrorPageRedirect || err == ErrorSsrOff {
+		os.Remove(fileName)
+		tlog.Infof("isr %s removed: %v", urlPath, err)
+		return nil
+	}
+	if err == nil && statusCode != http.StatusOK {
+		err = fmt.Errorf("status %d", statusCode)
+	}
+	if err == nil {
+		err = writeFileAtomic(fileName, indexHtml)
+	}
+	if err != nil {
+		tlog.Errorf("isr %s regenerate failed: %v", urlPath, err)
+		return err
+	}
+	tlog.Infof("isr %s regenerated, elapse: %v", urlPath, time.Since(beginTime))
+	return nil
+}
+
+func (this *Isr) endRefresh(urlPath string, err error) {
+	this.mutex.Lock()
+	if err != nil {
+		if state, ok := this.states[urlPath]; ok {
+			state.refreshing = false
+			state.failTime = time.Now()
+		}
+	} else {
+		delete(this.states, urlPath)
+	}
+	this.mutex.Unlock()
+}
+
+func isrUrlPath(urlPath string) string {
+	if urlPath != "" && urlPath[0] != '/' {
+		urlPath = "/" + urlPath
+	}
+	return strings.TrimSuffix(urlPath, "/")
+}
@@ -0,0 +1,40 @@
+package logic
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIsrCheck(t *testing.T) {
+	publicDir := t.TempDir()
+	isr := NewIsr(&IsrConfig{Rules: []IsrRule{
+		{Path: "/blog/", Revalidate: 60},
+		{Path: "/never/", Revalidate: 0},
+	}}, publicDir)
+
+	fileName := publicDir + "/blog/a/" + IndexName
+	err := writeFileAtomic(fileName, "<html></html>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(fileName)
+	if string(content) != "<html></html>" {
+		t.Fatalf("unexpected content: %s", content)
+	}
+
+	if state := isr.Check("/blog/a/", fileName); state != PageCacheHit {
+		t.Errorf("fresh page state: %s", state)
+	}
+	if isr.MatchRule("/never/a") != nil {
+		t.Error("rule without revalidate should be ignored")
+	}
+
+	// mark the page as being regenerated so that no render is started
+	isr.states["/blog/a"] = &isrState{refreshing: true}
+	old := time.Now().Add(-2 * time.Minute)
+	os.Chtimes(fileName, old, old)
+	if state := isr.Check("/blog/a", fileName); state != PageCacheStale {
+		t.Errorf("stale page state: %s", state)
+	}
+}
@@ -23,6 +23,8 @@ type RenderResult struct {
 	Meta    string `json:"meta"`
 	State   string `json:"state"`
 	Modules string `json:"modules"`
+
+	Response *RenderResponse `json:"response,omitempty"`
 }
 
 const renderJsName = "render.js"
@@ -30,7 +32,44 @@ const renderJsName = "render.js"
 const renderJsContent = `
 (function() {
 	let ctx = $RENDER_CONTEXT;
-	v8goRenderToString(ctx).then((html) => {
+	const sendResponse = () => {
+		const res = {};
+		if (typeof ctx.status === 'number') {
+			res.status = ctx.status;
+		}
+		if (ctx.headers && typeof ctx.headers === 'object') {
+			res.headers = {};
+			for (const k in ctx.headers) {
+				res.headers[k] = String(ctx.headers[k]);
+			}
+		}
+		if (Array.isArray(ctx.cookies) && ctx.cookies.length > 0) {
+			res.cookies = ctx.cookies.map((c) => typeof c === 'string' ? {raw: c} : c);
+		}
+		if (typeof ctx.redirect === 'string') {
+			res.redirect = {url: ctx.redirect};
+		} else if (ctx.redirect && typeof ctx.redirect === 'object') {
+			res.redirect = ctx.redirect;
+		}
+		if (Object.keys(res).length > 0) {
+			v8goGo.sendMessage(13, ctx.renderId, JSON.stringify(res), '', '', '');
+		}
+	};
+	let promise;
+	if (ctx.stream && typeof v8goRenderToStream === 'function') {
+		const renderId = ctx.renderId;
+		let started = false;
+		promise = v8goRenderToStream(ctx, (chunk) => {
+			if (!started) {
+				started = true;
+				sendResponse();
+			}
+			v8goGo.sendMessage(12, renderId, chunk, '', '', '');
+		});
+	} else {
+		promise = v8goRenderToString(ctx);
+	}
+	promise.then((html) => {
 		let meta = '';
 		let state = '';
 		let modules = '';
@@ -44,9 +83,11 @@ const renderJsContent = `
 			modules = ctx.htmlModules;
 		}
 
+		sendResponse();
 		v8goGo.sendMessage(10, ctx.renderId, html, meta, state, modules);
 		ctx = null;
 	}).catch((err) => {
+		sendResponse();
 		v8goGo.sendMessage(11, ctx.renderId, err.stack, '', '', '');
 		ctx = null;
 	})
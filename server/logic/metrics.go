@@ -0,0 +1,45 @@
+package logic
+
+import (
+	"github.com/lizc2003/vue-ssr-v8go/server/common/metrics"
+	"github.com/lizc2003/vue-ssr-v8go/server/v8"
+	"strconv"
+	"time"
+)
+
+var (
+	metricRenderDuration = metrics.NewHistogramVec("vssr_render_duration_seconds",
+		"Time taken to serve ssr requests.", []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 20},
+		"status", "outcome")
+	metricPageCache = metrics.NewCounterVec("vssr_page_cache_requests_total",
+		"Number of page cache lookups by result.", "result")
+	metricFallback = metrics.NewCounterVec("vssr_ssr_fallback_total",
+		"Number of requests served with the client-side rendering shell, by reason.", "reason")
+)
+
+func observeRender(statusCode int, err error, elapse time.Duration) {
+	metricRenderDuration.Observe(elapse.Seconds(), strconv.Itoa(statusCode), renderOutcome(err))
+}
+
+func renderOutcome(err error) string {
+	switch err {
+	case nil:
+		return "ok"
+	case ErrorSsrOff:
+		return "ssr-off"
+	case ErrorPageNotFound:
+		return "404"
+	case ErrorPageRedirect:
+		return "redirect"
+	case ErrorRenderTimeout:
+		return "timeout"
+	case ErrorRenderCancel:
+		return "canceled"
+	case ErrorSsrBypass:
+		return "bypass"
+	case v8.ErrorNoVm:
+		return "saturated"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,230 @@
+package logic
+
+import (
+	"container/list"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PageCacheMiss  = "MISS"
+	PageCacheHit   = "HIT"
+	PageCacheStale = "STALE"
+
+	DefaultPageCacheBytes = 64 * 1024 * 1024
+)
+
+type PageCacheRule struct {
+	Path                 string   `toml:"path"`
+	Ttl                  int      `toml:"ttl"`
+	StaleWhileRevalidate int      `toml:"stale_while_revalidate"`
+	Vary                 []string `toml:"vary"`
+}
+
+type PageCacheConfig struct {
+	MaxBytes int64           `toml:"max_bytes"`
+	Rules    []PageCacheRule `toml:"rule"`
+}
+
+type pageCacheEntry struct {
+	key        string
+	html       string
+	freshTime  time.Time
+	staleTime  time.Time
+	refreshing bool
+	elem       *list.Element
+}
+
+type PageCache struct {
+	mutex    sync.Mutex
+	rules    []PageCacheRule
+	entries  map[string]*pageCacheEntry
+	lru      *list.List
+	curBytes int64
+	maxBytes int64
+}
+
+func NewPageCache(c *PageCacheConfig) *PageCache {
+	if len(c.Rules) == 0 {
+		return nil
+	}
+
+	rules := make([]PageCacheRule, 0, len(c.Rules))
+	for _, rule := range c.Rules {
+		if rule.Ttl <= 0 {
+			continue
+		}
+		if rule.StaleWhileRevalidate < 0 {
+			rule.StaleWhileRevalidate = 0
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].Path) > len(rules[j].Path)
+	})
+
+	maxBytes := c.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultPageCacheBytes
+	}
+
+	return &PageCache{
+		rules:    rules,
+		entries:  make(map[string]*pageCacheEntry),
+		lru:      list.New(),
+		maxBytes: maxBytes,
+	}
+}
+
+func (this *PageCache) MatchRule(urlPath string) *PageCacheRule {
+	for i := range this.rules {
+		if MatchPath(urlPath, this.rules[i].Path) {
+			return &this.rules[i]
+		}
+	}
+	return nil
+}
+
+// Key builds the cache key from the url and the values of the rule's vary
+// list. A vary item is either a header name or "cookie:<name>".
+func (this *PageCacheRule) Key(url string, request *http.Request) string {
+	if len(this.Vary) == 0 {
+		return url
+	}
+
+	var sb strings.Builder
+	sb.WriteString(url)
+	for _, v := range this.Vary {
+		sb.WriteByte('\n')
+		if name, ok := strings.CutPrefix(v, "cookie:"); ok {
+			if c, err := request.Cookie(name); err == nil {
+				sb.WriteString(c.Value)
+			}
+		} else {
+			sb.WriteString(request.Header.Get(v))
+		}
+	}
+	return sb.String()
+}
+
+// RefreshRequest builds the request of a background refresh from the url and
+// only the headers and cookies the key varies on, so that the personalised
+// render of one visitor is never cached for everyone.
+func (this *PageCacheRule) RefreshRequest(ssrReq *SsrRequest, request *http.Request) *SsrRequest {
+	req := newStaticSsrRequest(ssrReq.Url)
+	req.Host = ssrReq.Host
+	req.Protocol = ssrReq.Protocol
+
+	var cookies []string
+	for _, v := range this.Vary {
+		if name, ok := strings.CutPrefix(v, "cookie:"); ok {
+			if _, forwarded := ssrReq.Headers["Cookie"]; !forwarded {
+				continue
+			}
+			if c, err := request.Cookie(name); err == nil {
+				cookies = append(cookies, c.String())
+			}
+		} else {
+			k := http.CanonicalHeaderKey(v)
+			if value, forwarded := ssrReq.Headers[k]; forwarded {
+				req.Headers[k] = value
+			}
+		}
+	}
+	if len(cookies) > 0 {
+		req.Headers["Cookie"] = strings.Join(cookies, "; ")
+	}
+	return req
+}
+
+// Get returns the cached html and its state. When the entry is stale and
+// no refresh is in progress, bRefresh is true and the caller must refresh
+// the page and call EndRefresh.
+func (this *PageCache) Get(key string) (html string, state string, bRefresh bool) {
+	now := time.Now()
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	entry, ok := this.entries[key]
+	if !ok {
+		return "", PageCacheMiss, false
+	}
+	if now.Before(entry.freshTime) {
+		this.lru.MoveToFront(entry.elem)
+		return entry.html, PageCacheHit, false
+	}
+	if now.Before(entry.staleTime) {
+		this.lru.MoveToFront(entry.elem)
+		if !entry.refreshing {
+			entry.refreshing = true
+			bRefresh = true
+		}
+		return entry.html, PageCacheStale, bRefresh
+	}
+
+	this.remove(entry)
+	return "", PageCacheMiss, false
+}
+
+func (this *PageCache) Set(key string, html string, rule *PageCacheRule) {
+	size := int64(len(key) + len(html))
+	if size > this.maxBytes {
+		return
+	}
+
+	now := time.Now()
+	freshTime := now.Add(time.Duration(rule.Ttl) * time.Second)
+	staleTime := freshTime.Add(time.Duration(rule.StaleWhileRevalidate) * time.Second)
+
+	this.mutex.Lock()
+	if entry, ok := this.entries[key]; ok {
+		this.remove(entry)
+	}
+	entry := &pageCacheEntry{
+		key:       key,
+		html:      html,
+		freshTime: freshTime,
+		staleTime: staleTime,
+	}
+	entry.elem = this.lru.PushFront(entry)
+	this.entries[key] = entry
+	this.curBytes += size
+
+	for this.curBytes > this.maxBytes {
+		back := this.lru.Back()
+		if back == nil {
+			break
+		}
+		this.remove(back.Value.(*pageCacheEntry))
+	}
+	this.mutex.Unlock()
+}
+
+func (this *PageCache) EndRefresh(key string) {
+	this.mutex.Lock()
+	if entry, ok := this.entries[key]; ok {
+		entry.refreshing = false
+	}
+	this.mutex.Unlock()
+}
+
+func (this *PageCache) remove(entry *pageCacheEntry) {
+	this.lru.Remove(entry.elem)
+	delete(this.entries, entry.key)
+	this.curBytes -= int64(len(entry.key) + len(entry.html))
+}
+
+func (this *PageCache) Purge() {
+	this.mutex.Lock()
+	clear(this.entries)
+	this.lru.Init()
+	this.curBytes = 0
+	this.mutex.Unlock()
+}
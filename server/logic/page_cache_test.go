@@ -0,0 +1,94 @@
+package logic
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPageCache(t *testing.T) {
+	cache := NewPageCache(&PageCacheConfig{
+		MaxBytes: 100,
+		Rules: []PageCacheRule{
+			{Path: "/", Ttl: 60},
+			{Path: "/news/", Ttl: 1, StaleWhileRevalidate: 60, Vary: []string{"Accept-Language", "cookie:lang"}},
+		},
+	})
+
+	rule := cache.MatchRule("/news/1")
+	if rule == nil || rule.Path != "/news/" {
+		t.Fatalf("unexpected rule: %v", rule)
+	}
+
+	request, _ := http.NewRequest("GET", "/news/1", nil)
+	request.Header.Set("Accept-Language", "en")
+	request.AddCookie(&http.Cookie{Name: "lang", Value: "fr"})
+	key := rule.Key("/news/1", request)
+	if key != "/news/1\nen\nfr" {
+		t.Errorf("unexpected key: %q", key)
+	}
+
+	if _, state, _ := cache.Get(key); state != PageCacheMiss {
+		t.Errorf("expect miss, got %s", state)
+	}
+	cache.Set(key, "html", rule)
+	if html, state, _ := cache.Get(key); state != PageCacheHit || html != "html" {
+		t.Errorf("expect hit, got %s %s", state, html)
+	}
+
+	cache.entries[key].freshTime = time.Now().Add(-time.Second)
+	if _, state, bRefresh := cache.Get(key); state != PageCacheStale || !bRefresh {
+		t.Errorf("expect stale with refresh, got %s %v", state, bRefresh)
+	}
+	if _, state, bRefresh := cache.Get(key); state != PageCacheStale || bRefresh {
+		t.Errorf("expect stale without refresh, got %s %v", state, bRefresh)
+	}
+	cache.EndRefresh(key)
+
+	rootRule := cache.MatchRule("/a")
+	cache.Set("/a", strings.Repeat("a", 40), rootRule)
+	cache.Set("/b", strings.Repeat("b", 40), rootRule)
+	if _, ok := cache.entries[key]; ok {
+		t.Errorf("expect lru entry to be evicted")
+	}
+	if cache.curBytes > cache.maxBytes {
+		t.Errorf("cache size %d exceeds %d", cache.curBytes, cache.maxBytes)
+	}
+	cache.Set("/c", strings.Repeat("c", 200), rootRule)
+	if _, state, _ := cache.Get("/c"); state != PageCacheMiss {
+		t.Errorf("oversized page should not be cached")
+	}
+}
+
+func TestPageCacheRefreshRequest(t *testing.T) {
+	rule := &PageCacheRule{Path: "/news/", Vary: []string{"Accept-Language", "cookie:lang"}}
+
+	request, _ := http.NewRequest("GET", "/news/1?a=1", nil)
+	request.Header.Set("Accept-Language", "en")
+	request.Header.Set("Authorization", "Bearer user")
+	request.AddCookie(&http.Cookie{Name: "lang", Value: "fr"})
+	request.AddCookie(&http.Cookie{Name: "sid", Value: "secret"})
+	ssrReq := &SsrRequest{
+		Url: "/news/1?a=1",
+		Headers: map[string]string{
+			"Accept-Language": "en",
+			"Authorization":   "Bearer user",
+			"Cookie":          request.Header.Get("Cookie"),
+		},
+		Host:     "example.com",
+		Protocol: "https",
+		Ip:       "10.0.0.1",
+	}
+
+	req := rule.RefreshRequest(ssrReq, request)
+	if req.Headers["Accept-Language"] != "en" || req.Headers["Cookie"] != "lang=fr" {
+		t.Errorf("vary headers not kept: %v", req.Headers)
+	}
+	if _, ok := req.Headers["Authorization"]; ok {
+		t.Errorf("authorization forwarded to the refresh: %v", req.Headers)
+	}
+	if req.Path != "/news/1" || req.Query["a"][0] != "1" || req.Host != "example.com" || req.Ip != "" {
+		t.Errorf("unexpected request: %+v", req)
+	}
+}
@@ -0,0 +1,161 @@
+package logic
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type prerenderReport struct {
+	ok        int
+	notFound  []string
+	redirects []string
+	skipped   []string
+	errors    []string
+}
+
+// RunPrerender renders the urls listed in c.Prerender, one per line, and
+// writes each page to <public dir>/<path>/index.html. It returns false when
+// any url failed to render.
+func RunPrerender(c *Config) bool {
+	publicDir, err := initServer(c)
+	if err != nil {
+		tlog.Error(err)
+		fmt.Println(err)
+		return false
+	}
+
+	urls, err := readPrerenderRoutes(c.Prerender)
+	if err != nil {
+		tlog.Error(err)
+		fmt.Println(err)
+		return false
+	}
+
+	var report prerenderReport
+	for _, url := range urls {
+		prerenderUrl(publicDir, url, &report)
+	}
+
+	fmt.Printf("\nprerendered: %d, not found: %d, redirects: %d, skipped: %d, errors: %d\n",
+		report.ok, len(report.notFound), len(report.redirects), len(report.skipped), len(report.errors))
+	for _, section := range []struct {
+		name  string
+		lines []string
+	}{
+		{"not found", report.notFound},
+		{"redirects", report.redirects},
+		{"skipped", report.skipped},
+		{"errors", report.errors},
+	} {
+		if len(section.lines) > 0 {
+			fmt.Printf("%s:\n", section.name)
+			for _, line := range section.lines {
+				fmt.Printf("  %s\n", line)
+			}
+		}
+	}
+	return len(report.errors) == 0
+}
+
+func prerenderUrl(publicDir string, url string, report *prerenderReport) {
+	if strings.Contains(url, "?") {
+		report.skipped = append(report.skipped, url+": query strings cannot be mapped to files")
+		return
+	}
+	urlPath := strings.TrimSuffix(url, "/")
+	if urlPath == "" {
+		report.skipped = append(report.skipped, url+": it would overwrite the "+IndexName+" template")
+		return
+	}
+
+	beginTime := time.Now()
+	statusCode, indexHtml, err := renderStaticPage(url)
+	elapse := time.Since(beginTime)
+
+	switch {
+	case err == ErrorPageNotFound:
+		report.notFound = append(report.notFound, url)
+	case err == ErrorPageRedirect:
+		report.redirects = append(report.redirects, fmt.Sprintf("%s -> %d %s", url, statusCode, indexHtml))
+	case err == ErrorSsrOff:
+		report.skipped = append(report.skipped, url+": ssr off")
+	case err != nil:
+		report.errors = append(report.errors, fmt.Sprintf("%s: %v", url, err))
+	case statusCode != http.StatusOK:
+		report.errors = append(report.errors, fmt.Sprintf("%s: status %d", url, statusCode))
+	default:
+		fileName := filepath.Join(publicDir, urlPath, IndexName)
+		err = writeFileAtomic(fileName, indexHtml)
+		if err != nil {
+			report.errors = append(report.errors, fmt.Sprintf("%s: %v", url, err))
+			return
+		}
+		report.ok++
+		fmt.Printf("%s -> %s, elapse: %v\n", url, fileName, elapse)
+	}
+}
+
+// renderStaticPage renders url without any request headers.
+func renderStaticPage(url string) (int, string, error) {
+	render := ThisServer.RenderMgr.NewRender(context.Background(), false)
+	result, err := ssrRender(render, newStaticSsrRequest(url), nil)
+	return ThisServer.RenderMgr.IndexHtml.Load().GetIndexHtml(result, err)
+}
+
+func readPrerenderRoutes(fileName string) ([]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var urls []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] != '/' {
+			line = "/" + line
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// writeFileAtomic writes to a temporary file and renames it, so that a
+// reader never sees a partially written page.
+func writeFileAtomic(fileName string, content string) error {
+	dir := filepath.Dir(fileName)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(fileName)+".*")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	_, err = f.WriteString(content)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, fileName)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
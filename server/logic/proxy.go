@@ -97,35 +97,7 @@ func makeReverseProxy(target *url.URL, pattern, replaceVal string) *httputil.Rev
 			}
 			newCookies := make([]string, 0, len(cookies))
 			for _, sc := range cookies {
-				parts := strings.Split(sc, ";")
-				replaced := false
-				sz := len(parts)
-				for i := 0; i < sz; i++ {
-					p := strings.ToLower(strings.TrimSpace(parts[i]))
-					if strings.HasPrefix(p, "domain=") {
-						parts[i] = "Domain=" + fwdHost
-						replaced = true
-					}
-					if isHttp {
-						if strings.HasPrefix(p, "secure") ||
-							strings.HasPrefix(p, "samesite=") {
-							parts[i] = ""
-						}
-					}
-				}
-				if replaced {
-					idx := 0
-					for i := 0; i < sz; i++ {
-						if parts[i] == "" {
-							continue
-						}
-						parts[idx] = strings.TrimSpace(parts[i])
-						idx++
-					}
-					newCookies = append(newCookies, strings.Join(parts[:idx], "; "))
-				} else {
-					newCookies = append(newCookies, sc)
-				}
+				newCookies = append(newCookies, rewriteSetCookie(sc, fwdHost, isHttp))
 			}
 			resp.Header.Del("Set-Cookie")
 			for _, nc := range newCookies {
@@ -136,6 +108,40 @@ func makeReverseProxy(target *url.URL, pattern, replaceVal string) *httputil.Rev
 	}
 }
 
+// rewriteSetCookie points the cookie domain at the forwarded host, and drops
+// Secure and SameSite when the client is on plain http.
+func rewriteSetCookie(sc string, fwdHost string, isHttp bool) string {
+	parts := strings.Split(sc, ";")
+	replaced := false
+	sz := len(parts)
+	for i := 0; i < sz; i++ {
+		p := strings.ToLower(strings.TrimSpace(parts[i]))
+		if strings.HasPrefix(p, "domain=") {
+			parts[i] = "Domain=" + fwdHost
+			replaced = true
+		}
+		if isHttp {
+			if strings.HasPrefix(p, "secure") ||
+				strings.HasPrefix(p, "samesite=") {
+				parts[i] = ""
+			}
+		}
+	}
+	if !replaced {
+		return sc
+	}
+
+	idx := 0
+	for i := 0; i < sz; i++ {
+		if parts[i] == "" {
+			continue
+		}
+		parts[idx] = strings.TrimSpace(parts[i])
+		idx++
+	}
+	return strings.Join(parts[:idx], "; ")
+}
+
 type ProxyLocation struct {
 	Path    string   `toml:"path"`
 	Target  string   `toml:"target"`
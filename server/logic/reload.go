@@ -0,0 +1,48 @@
+package logic
+
+import (
+	"github.com/lizc2003/vue-ssr-v8go/server/common/alarm"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var gReloadMutex sync.Mutex
+
+// ReloadBundle loads server.js and index.html of a new frontend deployment
+// without restarting the process. Nothing is swapped in unless both load.
+func ReloadBundle() error {
+	gReloadMutex.Lock()
+	defer gReloadMutex.Unlock()
+
+	indexHtml, err := NewIndexHtml(ThisServer.RenderMgr.env, ThisServer.RenderMgr.publicDir)
+	if err != nil {
+		return err
+	}
+	err = ThisServer.VmMgr.Reload()
+	if err != nil {
+		return err
+	}
+	ThisServer.RenderMgr.IndexHtml.Store(indexHtml)
+
+	if ThisServer.PageCache != nil {
+		ThisServer.PageCache.Purge()
+	}
+	tlog.Info("bundle reloaded")
+	return nil
+}
+
+func runReloadSignalRoutine() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		err := ReloadBundle()
+		if err != nil {
+			errMsg := "bundle reload refused: " + err.Error()
+			tlog.Error(errMsg)
+			alarm.SendAlert(errMsg)
+		}
+	}
+}
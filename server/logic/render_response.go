@@ -0,0 +1,166 @@
+package logic
+
+import (
+	"encoding/json"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RenderResponse is set by the app through ctx.status, ctx.headers,
+// ctx.cookies and ctx.redirect during render.
+type RenderResponse struct {
+	Status   int               `json:"status"`
+	Headers  map[string]string `json:"headers"`
+	Cookies  []RenderCookie    `json:"cookies"`
+	Redirect *RenderRedirect   `json:"redirect"`
+
+	// Set-Cookie headers of the origin api responses, see ForwardSetCookies.
+	setCookies []string
+}
+
+type RenderCookie struct {
+	Raw      string `json:"raw"`
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path"`
+	Domain   string `json:"domain"`
+	MaxAge   int    `json:"maxAge"`
+	Expires  string `json:"expires"`
+	Secure   bool   `json:"secure"`
+	HttpOnly bool   `json:"httpOnly"`
+	SameSite string `json:"sameSite"`
+}
+
+type RenderRedirect struct {
+	Url    string `json:"url"`
+	Status int    `json:"status"`
+}
+
+// headers that are managed by the server and cannot be set by the app.
+var protectedResponseHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Content-Type":      true,
+	"Keep-Alive":        true,
+	"Set-Cookie":        true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+func parseRenderResponse(renderId int64, s string) *RenderResponse {
+	var resp RenderResponse
+	err := json.Unmarshal([]byte(s), &resp)
+	if err != nil {
+		tlog.Errorf("render %d invalid response: %v", renderId, err)
+		return nil
+	}
+
+	if resp.Status != 0 && (resp.Status < 200 || resp.Status > 599) {
+		tlog.Errorf("render %d invalid status: %d", renderId, resp.Status)
+		resp.Status = 0
+	}
+	if resp.Redirect != nil {
+		switch resp.Redirect.Status {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+			http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			resp.Redirect.Status = http.StatusFound
+		}
+		if resp.Redirect.Url == "" {
+			resp.Redirect = nil
+		}
+	}
+	for k := range resp.Headers {
+		if protectedResponseHeaders[http.CanonicalHeaderKey(k)] {
+			tlog.Errorf("render %d header %s is not allowed", renderId, k)
+			delete(resp.Headers, k)
+		}
+	}
+	return &resp
+}
+
+// isCacheable reports whether a page with this response can be shared by the
+// page cache; headers and cookies are not kept by the cache.
+func (this *RenderResponse) isCacheable() bool {
+	return this == nil || (len(this.Headers) == 0 && len(this.Cookies) == 0 && len(this.setCookies) == 0)
+}
+
+func (this *RenderResponse) apply(writer http.ResponseWriter, request *http.Request) {
+	if this == nil {
+		return
+	}
+	for k, v := range this.Headers {
+		writer.Header().Set(k, v)
+	}
+	for i := range this.Cookies {
+		if cookie := this.Cookies[i].String(); cookie != "" {
+			writer.Header().Add("Set-Cookie", cookie)
+		}
+	}
+	if len(this.setCookies) > 0 {
+		host := request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		isHttp := request.TLS == nil && request.Header.Get("X-Forwarded-Proto") != "https"
+		for _, sc := range this.setCookies {
+			writer.Header().Add("Set-Cookie", rewriteSetCookie(sc, host, isHttp))
+		}
+	}
+}
+
+// isForwardSetCookie reports whether an upstream Set-Cookie matches the
+// forward_set_cookies list. An item ending with * matches a name prefix.
+func isForwardSetCookie(sc string, names []string) bool {
+	name, _, ok := strings.Cut(sc, "=")
+	if !ok {
+		return false
+	}
+	name = strings.TrimSpace(name)
+	for _, n := range names {
+		if prefix, ok := strings.CutSuffix(n, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *RenderCookie) String() string {
+	if this.Raw != "" {
+		return this.Raw
+	}
+
+	cookie := http.Cookie{
+		Name:     this.Name,
+		Value:    this.Value,
+		Path:     this.Path,
+		Domain:   this.Domain,
+		MaxAge:   this.MaxAge,
+		Secure:   this.Secure,
+		HttpOnly: this.HttpOnly,
+	}
+	if this.Expires != "" {
+		if t, err := time.Parse(time.RFC3339, this.Expires); err == nil {
+			cookie.Expires = t
+		} else if t, err = http.ParseTime(this.Expires); err == nil {
+			cookie.Expires = t
+		}
+	}
+	switch this.SameSite {
+	case "lax", "Lax":
+		cookie.SameSite = http.SameSiteLaxMode
+	case "strict", "Strict":
+		cookie.SameSite = http.SameSiteStrictMode
+	case "none", "None":
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	return cookie.String()
+}
@@ -0,0 +1,73 @@
+package logic
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseRenderResponse(t *testing.T) {
+	resp := parseRenderResponse(1, `{"status":410,"headers":{"X-App":"1","content-length":"5"},`+
+		`"cookies":[{"raw":"a=1; Path=/"},{"name":"sid","value":"abc","httpOnly":true,"sameSite":"lax"}],`+
+		`"redirect":{"url":"/new","status":200}}`)
+	if resp == nil {
+		t.Fatal("parse failed")
+	}
+	if resp.Status != 410 {
+		t.Errorf("status: %d", resp.Status)
+	}
+	if _, ok := resp.Headers["content-length"]; ok || resp.Headers["X-App"] != "1" {
+		t.Errorf("headers: %v", resp.Headers)
+	}
+	if resp.Redirect == nil || resp.Redirect.Status != http.StatusFound {
+		t.Errorf("redirect: %+v", resp.Redirect)
+	}
+	if s := resp.Cookies[0].String(); s != "a=1; Path=/" {
+		t.Errorf("raw cookie: %s", s)
+	}
+	if s := resp.Cookies[1].String(); s != "sid=abc; HttpOnly; SameSite=Lax" {
+		t.Errorf("cookie: %s", s)
+	}
+	if resp.isCacheable() {
+		t.Error("response with cookies should not be cacheable")
+	}
+
+	if resp = parseRenderResponse(1, `{"status":42}`); resp == nil || resp.Status != 0 {
+		t.Errorf("invalid status should be dropped: %+v", resp)
+	}
+}
+
+func TestIndexHtmlStructuredResponse(t *testing.T) {
+	index := &IndexHtml{indexHtml: "<html><!--app-html--></html>", ssrManifest: map[string][]string{}}
+
+	statusCode, html, err := index.GetIndexHtml(RenderResult{Html: "gone",
+		Response: &RenderResponse{Status: http.StatusGone}}, nil)
+	if err != nil || statusCode != http.StatusGone || html != "<html>gone</html>" {
+		t.Errorf("status response: %d %s %v", statusCode, html, err)
+	}
+
+	statusCode, url, err := index.GetIndexHtml(RenderResult{
+		Response: &RenderResponse{Redirect: &RenderRedirect{Url: "/new", Status: 301}}}, nil)
+	if err != ErrorPageRedirect || statusCode != 301 || url != "/new" {
+		t.Errorf("redirect response: %d %s %v", statusCode, url, err)
+	}
+}
+
+func TestForwardSetCookie(t *testing.T) {
+	names := []string{"sid", "pref_*"}
+	for sc, want := range map[string]bool{
+		"sid=1; Path=/":     true,
+		"pref_lang=en":      true,
+		"sidx=1":            false,
+		"other=1; Path=/":   false,
+		"malformed; Path=/": false,
+	} {
+		if got := isForwardSetCookie(sc, names); got != want {
+			t.Errorf("%s: %v", sc, got)
+		}
+	}
+
+	sc := rewriteSetCookie("sid=abc; Domain=api.example.com; Path=/; Secure; SameSite=None", "www.site.test", true)
+	if sc != "sid=abc; Domain=www.site.test; Path=/" {
+		t.Errorf("rewrite: %s", sc)
+	}
+}
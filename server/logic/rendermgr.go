@@ -1,22 +1,40 @@
 package logic
 
 import (
+	"context"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Render struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
 	end      chan struct{}
 	workerId int64
 	renderId int64
 	result   RenderResult
+	response *RenderResponse
 	bOK      bool
+
+	stream bool
+	chunks []string
+	notify chan struct{}
+
+	acquireWait time.Duration
+	jsTime      time.Duration
+	xhrCount    int
+	xhrTime     time.Duration
 }
 
 type RenderMgr struct {
 	mutex     sync.Mutex
 	renders   map[int64]*Render
 	maxId     int64
-	IndexHtml *IndexHtml
+	IndexHtml atomic.Pointer[IndexHtml]
+	env       string
+	publicDir string
 }
 
 func NewRenderMgr(env string, publicDir string) (*RenderMgr, error) {
@@ -25,15 +43,27 @@ func NewRenderMgr(env string, publicDir string) (*RenderMgr, error) {
 		return nil, err
 	}
 
-	return &RenderMgr{
+	mgr := &RenderMgr{
 		renders:   make(map[int64]*Render),
-		IndexHtml: indexHtml,
-	}, nil
+		env:       env,
+		publicDir: publicDir,
+	}
+	mgr.IndexHtml.Store(indexHtml)
+	return mgr, nil
 }
 
-func (this *RenderMgr) NewRender() *Render {
+// NewRender creates a render bound to ctx, usually the request context,
+// so that a client disconnect cancels the render.
+func (this *RenderMgr) NewRender(ctx context.Context, stream bool) *Render {
+	ctx, cancel := context.WithCancel(ctx)
 	req := &Render{
-		end: make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+		end:    make(chan struct{}),
+		stream: stream,
+	}
+	if stream {
+		req.notify = make(chan struct{}, 1)
 	}
 
 	this.mutex.Lock()
@@ -44,19 +74,95 @@ func (this *RenderMgr) NewRender() *Render {
 	return req
 }
 
-func (this *RenderMgr) CloseRender(renderId int64) {
+func (this *RenderMgr) CloseRender(render *Render) {
 	this.mutex.Lock()
-	delete(this.renders, renderId)
+	delete(this.renders, render.renderId)
 	this.mutex.Unlock()
+	render.cancel()
 }
 
 func (this *RenderMgr) SendResult(renderId int64, bOK bool, result RenderResult) {
 	this.mutex.Lock()
 	if req, ok := this.renders[renderId]; ok {
+		if bOK && result.Html == "" && !req.stream &&
+			(req.response == nil || req.response.Redirect == nil) {
+			bOK = false
+			result.Html = "no render result"
+		}
 		req.result = result
+		req.result.Response = req.response
 		req.bOK = bOK
 		close(req.end)
 		delete(this.renders, renderId)
+	} else {
+		tlog.Debugf("render %d is closed, result ignored", renderId)
+	}
+	this.mutex.Unlock()
+}
+
+func (this *RenderMgr) SendResponse(renderId int64, response *RenderResponse) {
+	if response == nil {
+		return
+	}
+	this.mutex.Lock()
+	if req, ok := this.renders[renderId]; ok {
+		if req.response != nil {
+			response.setCookies = req.response.setCookies
+		}
+		req.response = response
+	}
+	this.mutex.Unlock()
+}
+
+func (this *RenderMgr) AddSetCookie(renderId int64, setCookie string) {
+	this.mutex.Lock()
+	if req, ok := this.renders[renderId]; ok {
+		if req.response == nil {
+			req.response = &RenderResponse{}
+		}
+		req.response.setCookies = append(req.response.setCookies, setCookie)
+	} else {
+		tlog.Debugf("render %d is closed, set-cookie ignored", renderId)
+	}
+	this.mutex.Unlock()
+}
+
+func (this *RenderMgr) AddXhr(renderId int64, elapse time.Duration) {
+	this.mutex.Lock()
+	if req, ok := this.renders[renderId]; ok {
+		req.xhrCount++
+		req.xhrTime += elapse
+	}
+	this.mutex.Unlock()
+}
+
+// AddJsTime adds the time the render spent running js, excluding the waits
+// for xhrs and timers.
+func (this *RenderMgr) AddJsTime(renderId int64, elapse time.Duration) {
+	this.mutex.Lock()
+	if req, ok := this.renders[renderId]; ok {
+		req.jsTime += elapse
 	}
 	this.mutex.Unlock()
 }
+
+func (this *RenderMgr) SendChunk(renderId int64, chunk string) {
+	this.mutex.Lock()
+	if req, ok := this.renders[renderId]; ok && req.stream {
+		req.chunks = append(req.chunks, chunk)
+		select {
+		case req.notify <- struct{}{}:
+		default:
+		}
+	}
+	this.mutex.Unlock()
+}
+
+func (this *RenderMgr) takeChunks(render *Render) ([]string, *RenderResponse) {
+	this.mutex.Lock()
+	chunks := render.chunks
+	render.chunks = nil
+	response := render.response
+	this.mutex.Unlock()
+	return chunks, response
+}
@@ -1,24 +1,82 @@
 package logic
 
 import (
+	"github.com/lizc2003/vue-ssr-v8go/server/common/metrics"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/util"
 	"net/http"
+	"strings"
 )
 
-func GetHttpHandler(env string, publicDir string) http.Handler {
-	fileServer := http.FileServer(http.Dir(publicDir))
+func GetHttpHandler(env string, publicDir string, metricsPath string, readyPath string) http.Handler {
+	fileServer := http.FileServer(noDirFileSystem{http.Dir(publicDir)})
+	metricsHandler := metrics.Handler()
 
-	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+	serveHttp := func(writer http.ResponseWriter, request *http.Request) {
 		proxy := GetReverseProxy(request.URL.Path)
 		if proxy != nil {
+			setAccessOutcome(request, OutcomeProxy)
 			proxy.ServeHTTP(writer, request)
-		} else {
-			isExists, _ := util.FileExists(publicDir + request.URL.Path)
-			if isExists {
-				fileServer.ServeHTTP(writer, request)
+			return
+		}
+
+		fileName := publicDir + request.URL.Path
+		isExists, _ := util.FileExists(fileName)
+		if isExists {
+			setAccessOutcome(request, OutcomeStatic)
+			setCacheControl(writer, request.URL.Path)
+		}
+		compressor := ThisServer.Compressor
+		if compressor == nil {
+			serveContent(writer, request, publicDir, fileServer, isExists)
+		} else if !isExists || !compressor.ServeStatic(writer, request, fileName) {
+			compressor.Wrap(writer, request, func(w http.ResponseWriter) {
+				serveContent(w, request, publicDir, fileServer, isExists)
+			})
+		}
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if metricsPath != "" && request.URL.Path == metricsPath {
+			metricsHandler.ServeHTTP(writer, request)
+			return
+		}
+		if readyPath != "" && request.URL.Path == readyPath {
+			if ThisServer.VmMgr.IsReady() {
+				writer.Write([]byte("ready"))
 			} else {
-				HandleSsrRequest(writer, request)
+				http.Error(writer, "warming up", http.StatusServiceUnavailable)
 			}
+			return
 		}
+
+		withAccessLog(writer, request, serveHttp)
 	})
 }
+
+func serveContent(writer http.ResponseWriter, request *http.Request, publicDir string, fileServer http.Handler, isExists bool) {
+	if isExists {
+		fileServer.ServeHTTP(writer, request)
+	} else if pageFile := getPrerenderedFile(publicDir, request.URL.Path); pageFile != "" {
+		setAccessOutcome(request, OutcomePrerendered)
+		setCacheControl(writer, request.URL.Path)
+		writer.Header().Set("X-Cache", ThisServer.Isr.Check(request.URL.Path, pageFile))
+		http.ServeFile(writer, request, pageFile)
+	} else {
+		HandleSsrRequest(writer, request)
+	}
+}
+
+// getPrerenderedFile returns the <path>/index.html written by the prerender
+// command, if any. The root index.html is the template and never matches.
+func getPrerenderedFile(publicDir string, urlPath string) string {
+	urlPath = strings.TrimSuffix(urlPath, "/")
+	if urlPath == "" {
+		return ""
+	}
+	fileName := publicDir + urlPath + "/" + IndexName
+	isExists, _ := util.FileExists(fileName)
+	if isExists {
+		return fileName
+	}
+	return ""
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/alarm"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/trace"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/util"
 	"github.com/lizc2003/vue-ssr-v8go/server/v8"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
@@ -16,24 +18,50 @@ import (
 )
 
 type Config struct {
-	Host        string      `toml:"server_host"`
-	Env         string      `toml:"env"`
-	AlarmUrl    string      `toml:"alarm_url"`
-	AlarmSecret string      `toml:"alarm_secret"`
-	Log         tlog.Config `toml:"Log"`
-	VmConfig    v8.VmConfig `toml:"V8vm"`
-	SsrConfig   SSRConfig   `toml:"SSR"`
-	Proxy       ProxyConfig `toml:"Proxy"`
+	Host         string             `toml:"server_host"`
+	Env          string             `toml:"env"`
+	AlarmUrl     string             `toml:"alarm_url"`
+	AlarmSecret  string             `toml:"alarm_secret"`
+	MetricsPath  string             `toml:"metrics_path"`
+	ReadyPath    string             `toml:"ready_path"`
+	Log          tlog.Config        `toml:"Log"`
+	AccessLog    tlog.Config        `toml:"AccessLog"`
+	Trace        trace.Config       `toml:"Trace"`
+	Http         util.HttpConfig    `toml:"Http"`
+	VmConfig     v8.VmConfig        `toml:"V8vm"`
+	SsrConfig    SSRConfig          `toml:"SSR"`
+	Proxy        ProxyConfig        `toml:"Proxy"`
+	PageCache    PageCacheConfig    `toml:"PageCache"`
+	Compress     CompressConfig     `toml:"Compress"`
+	CacheControl CacheControlConfig `toml:"CacheControl"`
+	Isr          IsrConfig          `toml:"ISR"`
+	Fallback     FallbackConfig     `toml:"Fallback"`
+	Admin        AdminConfig        `toml:"Admin"`
+	Prerender    string             `toml:"-"`
 }
 
 type SSRConfig struct {
-	DistDir                     string   `toml:"dist_dir"`
-	Timeout                     int      `toml:"timeout"`
-	ResponseHeaders             []string `toml:"response_headers"`
-	AllowIframePaths            []string `toml:"allow_iframe_paths"`
-	AllowSharedArrayBufferPaths []string `toml:"allow_shared_array_buffer_paths"`
-	Origin                      string   `toml:"origin"`
-	OriginRewrite               string   `toml:"origin_rewrite"`
+	DistDir                     string          `toml:"dist_dir"`
+	Timeout                     int             `toml:"timeout"`
+	ResponseHeaders             []string        `toml:"response_headers"`
+	AllowIframePaths            []string        `toml:"allow_iframe_paths"`
+	AllowSharedArrayBufferPaths []string        `toml:"allow_shared_array_buffer_paths"`
+	StreamPaths                 []string        `toml:"stream_paths"`
+	ForwardSetCookies           []string        `toml:"forward_set_cookies"`
+	ForwardHeaders              []string        `toml:"forward_headers"`
+	Origin                      string          `toml:"origin"`
+	OriginRewrite               string          `toml:"origin_rewrite"`
+	Rewrites                    []RewriteConfig `toml:"rewrite"`
+}
+
+// RewriteConfig routes the api calls to Host, and under Path when it is
+// set, to Target. HostHeader is "original" (default), "target" or a host.
+type RewriteConfig struct {
+	Host           string `toml:"host"`
+	Path           string `toml:"path"`
+	Target         string `toml:"target"`
+	HostHeader     string `toml:"host_header"`
+	ForwardCookies bool   `toml:"forward_cookies"`
 }
 
 type Server struct {
@@ -44,25 +72,58 @@ type Server struct {
 	ResponseHeaders             map[string]string
 	AllowIframePaths            []string
 	AllowSharedArrayBufferPaths []string
+	StreamPaths                 []string
+	ForwardSetCookies           []string
+	ForwardHeaders              []string
+	PageCache                   *PageCache
+	Compressor                  *Compressor
+	CacheControl                *CacheControl
+	Fallback                    *Fallback
+	Isr                         *Isr
 }
 
 var ThisServer *Server
 
 func RunServer(c *Config) {
+	publicDir, err := initServer(c)
+	if err != nil {
+		tlog.Fatal(err.Error())
+		return
+	}
+
+	go runDumpSignalRoutine()
+	go runReloadSignalRoutine()
+	go runAdminServer(&c.Admin)
+
+	addrs := c.Http.Listen
+	if c.Host != "" {
+		addrs = append([]string{c.Host}, addrs...)
+	}
+	fmt.Printf("At %s, the server was started on %s.\n",
+		util.FormatTime(time.Now()),
+		strings.Join(addrs, ", "))
+	err = util.GraceHttpServe(addrs, &c.Http, GetHttpHandler(c.Env, publicDir, c.MetricsPath, c.ReadyPath))
+	if err != nil {
+		tlog.Fatal(err.Error())
+	}
+}
+
+// initServer creates the vm and render managers and sets ThisServer.
+// It returns the public dir of the dist.
+func initServer(c *Config) (string, error) {
 	if c.AlarmUrl != "" && c.AlarmSecret != "" {
 		alarm.NewDefaultRobot(c.Env, c.AlarmUrl, c.AlarmSecret)
 	}
+	trace.Init(&c.Trace)
 
 	err := InitReverseProxy(c.Proxy.Locations)
 	if err != nil {
-		tlog.Fatal(err.Error())
-		return
+		return "", err
 	}
 
 	distPath, err := getDistPath(c.SsrConfig.DistDir)
 	if err != nil {
-		tlog.Fatal(err.Error())
-		return
+		return "", err
 	}
 	publicDir := distPath + PublicPath
 	serverDir := distPath + ServerPath
@@ -77,23 +138,26 @@ func RunServer(c *Config) {
 		c.VmConfig.DeleteDelayTime = ssrTimeout
 	}
 
-	originRewrite, err := getOriginRewrite(c)
+	rewriteRules, err := getRewriteRules(c)
 	if err != nil {
-		tlog.Fatal(err.Error())
-		return
+		return "", err
 	}
-	vmMgr, err := v8.NewVmMgr(c.Env, serverDir, SendMessageCallback, &c.VmConfig, originRewrite)
+	vmMgr, err := v8.NewVmMgr(c.Env, serverDir, SendMessageCallback, &c.VmConfig, rewriteRules)
 	if err != nil {
-		tlog.Fatal(err.Error())
-		return
+		return "", err
 	}
 	vmMgr.DumpHeapDir = c.Log.Dir
+	vmMgr.ForwardSetCookie = len(c.SsrConfig.ForwardSetCookies) > 0
 	os.MkdirAll(vmMgr.DumpHeapDir, 0755)
 
 	renderMgr, err := NewRenderMgr(c.Env, publicDir)
 	if err != nil {
-		tlog.Fatal(err.Error())
-		return
+		return "", err
+	}
+
+	fallback, err := NewFallback(&c.Fallback)
+	if err != nil {
+		return "", err
 	}
 
 	originJson, _ := json.Marshal(c.SsrConfig.Origin)
@@ -106,18 +170,21 @@ func RunServer(c *Config) {
 		ResponseHeaders:             toResponseHeaders(c.SsrConfig.ResponseHeaders),
 		AllowIframePaths:            c.SsrConfig.AllowIframePaths,
 		AllowSharedArrayBufferPaths: c.SsrConfig.AllowSharedArrayBufferPaths,
+		StreamPaths:                 c.SsrConfig.StreamPaths,
+		ForwardSetCookies:           c.SsrConfig.ForwardSetCookies,
+		ForwardHeaders:              toForwardHeaders(c.SsrConfig.ForwardHeaders),
+		PageCache:                   NewPageCache(&c.PageCache),
+		Compressor:                  NewCompressor(&c.Compress),
+		CacheControl:                NewCacheControl(&c.CacheControl),
+		Fallback:                    fallback,
+		Isr:                         NewIsr(&c.Isr, publicDir),
 	}
 
-	go runDumpSignalRoutine()
-
-	fmt.Printf("At %s, the server was started on port %s.\n",
-		util.FormatTime(time.Now()),
-		strings.Split(c.Host, ":")[1])
-	util.GraceHttpServe(c.Host, GetHttpHandler(c.Env, publicDir))
+	return publicDir, nil
 }
 
 func runDumpSignalRoutine() {
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGUSR2)
 	for {
 		sig := <-ch
@@ -139,7 +206,22 @@ func toResponseHeaders(headers []string) map[string]string {
 	return headersMap
 }
 
-func getOriginRewrite(c *Config) (*v8.OriginRewrite, error) {
+func toForwardHeaders(headers []string) []string {
+	if len(headers) == 0 {
+		return DefaultForwardHeaders
+	}
+	ret := make([]string, 0, len(headers))
+	for _, h := range headers {
+		if h = strings.TrimSpace(h); h != "" {
+			ret = append(ret, http.CanonicalHeaderKey(h))
+		}
+	}
+	return ret
+}
+
+// getRewriteRules returns the [[SSR.rewrite]] rules in order, followed by
+// the rule of the origin, which keeps the host and forwards cookies.
+func getRewriteRules(c *Config) ([]v8.RewriteRule, error) {
 	if c.SsrConfig.Origin == "" {
 		return nil, errors.New("ssr.origin is empty")
 	}
@@ -149,17 +231,45 @@ func getOriginRewrite(c *Config) (*v8.OriginRewrite, error) {
 		return nil, err
 	}
 
-	ret := &v8.OriginRewrite{
-		OriginHost: originUrl.Host,
+	rules := make([]v8.RewriteRule, 0, len(c.SsrConfig.Rewrites)+1)
+	for _, rc := range c.SsrConfig.Rewrites {
+		if rc.Host == "" {
+			return nil, errors.New("ssr.rewrite.host is empty")
+		}
+		rule := v8.RewriteRule{
+			Host:           rc.Host,
+			Path:           rc.Path,
+			ForwardCookies: rc.ForwardCookies,
+		}
+		if rc.Target != "" {
+			rule.Target, err = v8.ParseUrl(rc.Target)
+			if err != nil {
+				return nil, err
+			}
+		}
+		switch rc.HostHeader {
+		case "", "original":
+		case "target":
+			if rule.Target != nil {
+				rule.HostHeader = rule.Target.Host
+			}
+		default:
+			rule.HostHeader = rc.HostHeader
+		}
+		rules = append(rules, rule)
 	}
 
+	originRule := v8.RewriteRule{
+		Host:           originUrl.Host,
+		ForwardCookies: true,
+	}
 	if c.SsrConfig.OriginRewrite != "" {
-		rewriteUrl, err := v8.ParseUrl(c.SsrConfig.OriginRewrite)
+		originRule.Target, err = v8.ParseUrl(c.SsrConfig.OriginRewrite)
 		if err != nil {
 			return nil, err
 		}
-		ret.RewriteUrl = rewriteUrl
 	}
+	rules = append(rules, originRule)
 
-	return ret, nil
+	return rules, nil
 }
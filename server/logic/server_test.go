@@ -0,0 +1,37 @@
+package logic
+
+import (
+	"testing"
+)
+
+func TestGetRewriteRules(t *testing.T) {
+	c := &Config{}
+	c.SsrConfig.Origin = "https://www.example.com"
+	c.SsrConfig.OriginRewrite = "http://10.0.0.1:8080"
+	c.SsrConfig.Rewrites = []RewriteConfig{
+		{Host: "api.example.com", Target: "http://10.0.0.2", HostHeader: "target"},
+		{Host: "cms.example.com", Path: "/v2/", Target: "http://10.0.0.3/api", ForwardCookies: true},
+	}
+
+	rules, err := getRewriteRules(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("rules: %v", rules)
+	}
+	if rules[0].HostHeader != "10.0.0.2" || rules[0].ForwardCookies {
+		t.Errorf("rule 0: %+v", rules[0])
+	}
+	if rules[1].Path != "/v2/" || rules[1].HostHeader != "" || !rules[1].ForwardCookies {
+		t.Errorf("rule 1: %+v", rules[1])
+	}
+	if rules[2].Host != "www.example.com" || rules[2].Target.Host != "10.0.0.1:8080" || !rules[2].ForwardCookies {
+		t.Errorf("origin rule: %+v", rules[2])
+	}
+
+	c.SsrConfig.Rewrites = []RewriteConfig{{Target: "http://10.0.0.2"}}
+	if _, err = getRewriteRules(c); err == nil {
+		t.Errorf("a rule without host should be refused")
+	}
+}
@@ -1,11 +1,13 @@
 package logic
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/alarm"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/trace"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/util"
 	"net/http"
 	"strconv"
@@ -21,31 +23,118 @@ func HandleSsrRequest(writer http.ResponseWriter, request *http.Request) {
 		url += reqURL.RawQuery
 	}
 
-	ssrHeaders := make(map[string]string)
-	for _, k := range ForwardHeaders {
-		v := request.Header.Get(k)
-		if v == "" && k == "X-Forwarded-For" {
-			v = util.GetClientIP(request)
-		}
-		if v != "" {
-			ssrHeaders[k] = v
+	ssrReq := newSsrRequest(request, url)
+	writer.Header().Set("X-Request-Id", ssrReq.RequestId)
+
+	ctx := request.Context()
+	if sc, ok := trace.ParseTraceparent(request.Header.Get("Traceparent")); ok {
+		ctx = trace.ContextWithRemoteParent(ctx, sc)
+	}
+	ctx, span := trace.Start(ctx, "ssr.request", trace.SpanKindServer)
+	defer span.End()
+	span.SetAttr("http.request.method", request.Method)
+	span.SetAttr("url.path", reqURL.Path)
+	span.SetAttr("url.query", reqURL.RawQuery)
+	span.SetAttr("client.address", ssrReq.Ip)
+	span.SetAttr("ssr.request_id", ssrReq.RequestId)
+
+	var cacheRule *PageCacheRule
+	var cacheKey string
+	if ThisServer.PageCache != nil && request.Method == http.MethodGet {
+		cacheRule = ThisServer.PageCache.MatchRule(url)
+		if cacheRule != nil {
+			cacheKey = cacheRule.Key(url, request)
+			html, state, bRefresh := ThisServer.PageCache.Get(cacheKey)
+			metricPageCache.Inc(state)
+			if state != PageCacheMiss {
+				if bRefresh {
+					go refreshPageCache(cacheKey, cacheRule, cacheRule.RefreshRequest(ssrReq, request))
+				}
+				writer.Header().Set("X-Cache", state)
+				span.SetAttr("ssr.outcome", "cache-"+strings.ToLower(state))
+				setAccessOutcome(request, "cache-"+strings.ToLower(state))
+				writeHtmlPage(writer, request, url, html)
+				tlog.Infof("request cache %s: %s", state, url)
+				return
+			}
+			writer.Header().Set("X-Cache", PageCacheMiss)
 		}
 	}
 
-	render := ThisServer.RenderMgr.NewRender()
+	var stream *ssrStream
+	if isStreamPath(url) {
+		stream = newSsrStream(writer, request, url)
+	}
+
+	render := ThisServer.RenderMgr.NewRender(ctx, stream != nil)
+	span.SetAttr("ssr.render_id", render.renderId)
 	tlog.Infof("request %d: %s", render.renderId, url)
 
 	beginTime := time.Now()
 
-	result, err := ssrRender(render, url, ssrHeaders)
-	statusCode, indexHtml, err := ThisServer.RenderMgr.IndexHtml.GetIndexHtml(result, err)
+	var result RenderResult
+	var err error
+	if ThisServer.Fallback.Allow() {
+		if stream != nil {
+			stream.start()
+		}
+		result, err = ssrRender(render, ssrReq, stream)
+	} else {
+		ThisServer.RenderMgr.CloseRender(render)
+		err = ErrorSsrBypass
+	}
+	if stream != nil && stream.started {
+		stream.finish(result, err)
+		endSsrSpan(span, render, stream.status, err)
+		if err != nil {
+			// the page is already sent as 200, the client recovers with csr
+			span.SetError(err)
+			setAccessOutcome(request, "stream-"+renderOutcome(err))
+		} else {
+			setAccessOutcome(request, renderOutcome(err))
+		}
+		setAccessRender(request, render, 0)
+		ThisServer.Fallback.Report(err)
+		elapse := time.Since(beginTime)
+		observeRender(stream.status, err, elapse)
+		if err != nil {
+			errMsg := fmt.Sprintf("request %d finish(%d): %s, elapse: %v, stream error: %v", render.renderId, render.workerId, url, elapse, err)
+			tlog.Error(errMsg)
+			alarm.SendAlert(errMsg)
+		} else {
+			tlog.Infof("request %d finish(%d): %s, elapse: %v, streamed", render.renderId, render.workerId, url, elapse)
+		}
+		return
+	}
+
+	assembleTime := time.Now()
+	statusCode, indexHtml, err := ThisServer.RenderMgr.IndexHtml.Load().GetIndexHtml(result, err)
+	setAccessRender(request, render, time.Since(assembleTime))
+	setAccessOutcome(request, renderOutcome(err))
+	endSsrSpan(span, render, statusCode, err)
+	if err != ErrorSsrBypass {
+		// legacy 404 and redirect errors are only known after GetIndexHtml
+		ThisServer.Fallback.Report(err)
+	}
+	if reason := fallbackReason(err); reason != "" {
+		statusCode = ThisServer.Fallback.StatusCode(reason)
+		writer.Header().Set("X-SSR-Fallback", reason)
+		metricFallback.Inc(reason)
+	}
+	result.Response.apply(writer, request)
 	if err == ErrorPageRedirect {
 		http.Redirect(writer, request, indexHtml, statusCode)
+	} else if err == nil && statusCode == http.StatusOK {
+		writeHtmlPage(writer, request, url, indexHtml)
 	} else {
 		util.WriteHtmlResponse(writer, statusCode, indexHtml, getResponseHeaders(url))
 	}
+	if cacheRule != nil && err == nil && statusCode == http.StatusOK && result.Response.isCacheable() {
+		ThisServer.PageCache.Set(cacheKey, indexHtml, cacheRule)
+	}
 
 	elapse := time.Since(beginTime)
+	observeRender(statusCode, err, elapse)
 	if err != nil {
 		if err == ErrorSsrOff {
 			tlog.Infof("request %d finish(%d): %s, elapse: %v, ssr off", render.renderId, render.workerId, url, elapse)
@@ -53,6 +142,10 @@ func HandleSsrRequest(writer http.ResponseWriter, request *http.Request) {
 			tlog.Infof("request %d finish(%d): %s, elapse: %v, page not found", render.renderId, render.workerId, url, elapse)
 		} else if err == ErrorPageRedirect {
 			tlog.Infof("request %d finish(%d): %s, elapse: %v, page redirect %d %s", render.renderId, render.workerId, url, elapse, statusCode, indexHtml)
+		} else if err == ErrorSsrBypass {
+			tlog.Infof("request %d finish(%d): %s, elapse: %v, ssr bypassed by circuit breaker", render.renderId, render.workerId, url, elapse)
+		} else if err == ErrorRenderCancel {
+			tlog.Infof("request %d finish(%d): %s, elapse: %v, client canceled", render.renderId, render.workerId, url, elapse)
 		} else {
 			errMsg := fmt.Sprintf("request %d finish(%d): %s, elapse: %v, ssr error: %v", render.renderId, render.workerId, url, elapse, err)
 			tlog.Error(errMsg)
@@ -65,12 +158,56 @@ func HandleSsrRequest(writer http.ResponseWriter, request *http.Request) {
 	}
 }
 
-func ssrRender(render *Render, url string, ssrHeaders map[string]string) (RenderResult, error) {
-	ssrHeadersJson, _ := json.Marshal(ssrHeaders)
-	urlJson, _ := json.Marshal(url)
+// writeHtmlPage writes a rendered page with an ETag, and answers 304 when
+// the client already has it.
+func writeHtmlPage(writer http.ResponseWriter, request *http.Request, url string, html string) {
+	setCacheControl(writer, request.URL.Path)
+	etag := htmlEtag(html)
+	writer.Header().Set("ETag", etag)
+	if etagMatch(request.Header.Get("If-None-Match"), etag) {
+		for k, v := range getResponseHeaders(url) {
+			writer.Header().Set(k, v)
+		}
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+	util.WriteHtmlResponse(writer, http.StatusOK, html, getResponseHeaders(url))
+}
+
+func endSsrSpan(span *trace.Span, render *Render, statusCode int, err error) {
+	span.SetAttr("ssr.worker_id", render.workerId)
+	span.SetAttr("ssr.outcome", renderOutcome(err))
+	span.SetAttr("http.response.status_code", statusCode)
+	if reason := fallbackReason(err); reason != "" {
+		span.SetError(err)
+	}
+}
+
+func refreshPageCache(cacheKey string, cacheRule *PageCacheRule, ssrReq *SsrRequest) {
+	url := ssrReq.Url
+	defer ThisServer.PageCache.EndRefresh(cacheKey)
+
+	render := ThisServer.RenderMgr.NewRender(context.Background(), false)
+	beginTime := time.Now()
+	result, err := ssrRender(render, ssrReq, nil)
+	statusCode, indexHtml, err := ThisServer.RenderMgr.IndexHtml.Load().GetIndexHtml(result, err)
+	if err == nil && statusCode == http.StatusOK && result.Response.isCacheable() {
+		ThisServer.PageCache.Set(cacheKey, indexHtml, cacheRule)
+		tlog.Infof("request %d cache refreshed(%d): %s, elapse: %v", render.renderId, render.workerId, url, time.Since(beginTime))
+	} else {
+		tlog.Errorf("request %d cache refresh failed(%d): %s, error: %v", render.renderId, render.workerId, url, err)
+	}
+}
+
+// ssrRender executes the render script and waits for its result. When stream is
+// not nil, chunks pushed by the render are written out as soon as they arrive.
+func ssrRender(render *Render, ssrReq *SsrRequest, stream *ssrStream) (RenderResult, error) {
+	ssrHeadersJson, _ := json.Marshal(ssrReq.Headers)
+	urlJson, _ := json.Marshal(ssrReq.Url)
+	requestJson, _ := json.Marshal(ssrReq)
 
 	var jsCode strings.Builder
-	jsCode.Grow(renderJsLength + len(ssrHeadersJson) + len(urlJson) + len(ThisServer.Origin) + 64)
+	jsCode.Grow(renderJsLength + len(ssrHeadersJson) + len(urlJson) + len(requestJson) + len(ThisServer.Origin) + 64)
 	jsCode.WriteString(renderJsPart1)
 	jsCode.WriteString(`{renderId:`)
 	jsCode.WriteString(strconv.FormatInt(render.renderId, 10))
@@ -80,22 +217,53 @@ func ssrRender(render *Render, url string, ssrHeaders map[string]string) (Render
 	jsCode.WriteString(ThisServer.Origin)
 	jsCode.WriteString(`,ssrHeaders:`)
 	jsCode.Write(ssrHeadersJson)
+	jsCode.WriteString(`,request:`)
+	jsCode.Write(requestJson)
+	if stream != nil {
+		jsCode.WriteString(`,stream:true`)
+	}
 	jsCode.WriteString(`}`)
 	jsCode.WriteString(renderJsPart2)
 
-	workerId, err := ThisServer.VmMgr.Execute(jsCode.String(), renderJsName)
-	render.workerId = workerId
+	stat, err := ThisServer.VmMgr.ExecuteWithStat(render.ctx, render.renderId, jsCode.String(), renderJsName)
+	render.workerId = stat.WorkerId
+	render.acquireWait = stat.AcquireWait
+	if err != nil && render.ctx.Err() != nil {
+		err = ErrorRenderCancel
+	}
 	if err == nil {
-		select {
-		case <-render.end:
-			if !render.bOK {
-				err = errors.New(render.result.Html)
+		ctx, cancel := context.WithTimeout(render.ctx, ThisServer.SsrTime)
+		defer cancel()
+		_, waitSpan := trace.Start(render.ctx, "render.wait", trace.SpanKindInternal)
+		defer func() {
+			waitSpan.SetError(err)
+			waitSpan.End()
+		}()
+	LOOP:
+		for {
+			select {
+			case <-render.notify:
+				stream.write(ThisServer.RenderMgr.takeChunks(render))
+			case <-render.end:
+				if stream != nil {
+					stream.write(ThisServer.RenderMgr.takeChunks(render))
+				}
+				if !render.bOK {
+					err = errors.New(render.result.Html)
+				}
+				break LOOP
+			case <-ctx.Done():
+				if render.ctx.Err() != nil {
+					err = ErrorRenderCancel
+				} else {
+					err = ErrorRenderTimeout
+				}
+				break LOOP
 			}
-		case <-time.After(ThisServer.SsrTime):
-			err = ErrorRenderTimeout
 		}
 	}
-	ThisServer.RenderMgr.CloseRender(render.renderId)
+	ThisServer.RenderMgr.CloseRender(render)
+	ThisServer.VmMgr.CloseRender(render.renderId)
 
 	return render.result, err
 }
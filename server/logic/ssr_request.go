@@ -0,0 +1,93 @@
+package logic
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/util"
+	"net/http"
+	neturl "net/url"
+)
+
+const MaxRequestIdLength = 128
+
+// SsrRequest is what a render knows about the incoming request. Url and
+// Headers are passed as ctx.url and ctx.ssrHeaders, the rest as ctx.request.
+type SsrRequest struct {
+	Url     string            `json:"-"`
+	Headers map[string]string `json:"-"`
+
+	Method    string              `json:"method"`
+	Path      string              `json:"path"`
+	Query     map[string][]string `json:"query"`
+	Ip        string              `json:"ip"`
+	Host      string              `json:"host"`
+	Protocol  string              `json:"protocol"`
+	RequestId string              `json:"requestId"`
+}
+
+func newSsrRequest(request *http.Request, url string) *SsrRequest {
+	headers := make(map[string]string)
+	for _, k := range ThisServer.ForwardHeaders {
+		v := request.Header.Get(k)
+		if v == "" && k == "X-Forwarded-For" {
+			v = util.GetClientIP(request)
+		}
+		if v != "" {
+			headers[k] = v
+		}
+	}
+
+	host := request.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		host = request.Host
+	}
+	protocol := "http"
+	if request.TLS != nil {
+		protocol = "https"
+	} else if proto := request.Header.Get("X-Forwarded-Proto"); proto == "https" {
+		protocol = proto
+	}
+	requestId := request.Header.Get("X-Request-Id")
+	if requestId == "" || len(requestId) > MaxRequestIdLength {
+		requestId = newRequestId()
+	}
+	headers["X-Request-Id"] = requestId
+
+	return &SsrRequest{
+		Url:       url,
+		Headers:   headers,
+		Method:    request.Method,
+		Path:      request.URL.Path,
+		Query:     request.URL.Query(),
+		Ip:        util.GetClientIP(request),
+		Host:      host,
+		Protocol:  protocol,
+		RequestId: requestId,
+	}
+}
+
+// newStaticSsrRequest is used to render pages outside of a request, such as
+// prerendering.
+func newStaticSsrRequest(url string) *SsrRequest {
+	requestId := newRequestId()
+	req := &SsrRequest{
+		Url:       url,
+		Headers:   map[string]string{"X-Request-Id": requestId},
+		Method:    http.MethodGet,
+		Path:      url,
+		Query:     map[string][]string{},
+		Protocol:  "http",
+		RequestId: requestId,
+	}
+	if u, err := neturl.ParseRequestURI(url); err == nil {
+		req.Path = u.Path
+		req.Query = u.Query()
+	}
+	return req
+}
+
+func newRequestId() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,33 @@
+package logic
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewSsrRequest(t *testing.T) {
+	ThisServer = &Server{ForwardHeaders: toForwardHeaders([]string{"accept-language", " x-ab-group "})}
+	defer func() { ThisServer = nil }()
+
+	request := httptest.NewRequest("GET", "http://example.com/list?page=2&tag=a&tag=b", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	request.Header.Set("Accept-Language", "en")
+	request.Header.Set("X-Ab-Group", "b")
+	request.Header.Set("User-Agent", "test")
+	request.Header.Set("X-Forwarded-Proto", "https")
+	request.Header.Set("X-Request-Id", "abc")
+
+	req := newSsrRequest(request, "/list?page=2&tag=a&tag=b")
+	if req.Headers["Accept-Language"] != "en" || req.Headers["X-Ab-Group"] != "b" || req.Headers["X-Request-Id"] != "abc" {
+		t.Errorf("headers: %v", req.Headers)
+	}
+	if _, ok := req.Headers["User-Agent"]; ok {
+		t.Errorf("user-agent should not be forwarded: %v", req.Headers)
+	}
+	if req.Path != "/list" || len(req.Query["tag"]) != 2 || req.Query["page"][0] != "2" {
+		t.Errorf("path/query: %s %v", req.Path, req.Query)
+	}
+	if req.Ip != "10.0.0.1" || req.Host != "example.com" || req.Protocol != "https" || req.RequestId != "abc" {
+		t.Errorf("request: %+v", req)
+	}
+}
@@ -0,0 +1,71 @@
+package logic
+
+import (
+	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/util"
+	"net/http"
+)
+
+type ssrStream struct {
+	writer  http.ResponseWriter
+	request *http.Request
+	flusher http.Flusher
+	url     string
+	index   *IndexHtml
+	tail    string
+	status  int
+	started bool
+	warned  bool
+}
+
+func newSsrStream(writer http.ResponseWriter, request *http.Request, url string) *ssrStream {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		return nil
+	}
+	return &ssrStream{
+		writer:  writer,
+		request: request,
+		flusher: flusher,
+		url:     url,
+	}
+}
+
+// start sends the headers and the head of index.html before the render
+// runs. A status, cookie or redirect set by the render comes too late and is
+// ignored.
+func (this *ssrStream) start() {
+	this.started = true
+	this.index = ThisServer.RenderMgr.IndexHtml.Load()
+	head, tail := this.index.GetStreamParts()
+	this.tail = tail
+
+	for k, v := range getResponseHeaders(this.url) {
+		this.writer.Header().Set(k, v)
+	}
+	this.writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	this.status = http.StatusOK
+	this.writer.WriteHeader(this.status)
+	this.writer.Write(util.UnsafeStr2Bytes(head))
+	this.flusher.Flush()
+}
+
+func (this *ssrStream) write(chunks []string, response *RenderResponse) {
+	if response != nil && !this.warned {
+		this.warned = true
+		tlog.Warnf("streamed page %s: response status, cookies and redirect of the render ignored", this.url)
+	}
+	if len(chunks) == 0 {
+		return
+	}
+	for _, chunk := range chunks {
+		this.writer.Write(util.UnsafeStr2Bytes(chunk))
+	}
+	this.flusher.Flush()
+}
+
+func (this *ssrStream) finish(result RenderResult, err error) {
+	tail := this.index.GetStreamTail(this.tail, result, err)
+	this.writer.Write(util.UnsafeStr2Bytes(tail))
+	this.flusher.Flush()
+}
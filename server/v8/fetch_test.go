@@ -0,0 +1,102 @@
+package v8_test
+
+import (
+	v8 "github.com/lizc2003/vue-ssr-v8go/server/v8"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":1,"name":"中文"}`))
+		case "/echo":
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Method", r.Method)
+			w.Header().Set("X-Content-Type", r.Header.Get("Content-Type"))
+			w.WriteHeader(201)
+			w.Write(body)
+		case "/slow":
+			time.Sleep(2 * time.Second)
+			w.Write([]byte("slow"))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	results := make(chan string, 10)
+	callback := func(mtype int64, param1 int64, param2 string, param3 string, param4 string, param5 string) {
+		results <- param2
+	}
+	vmMgr, err := v8.NewVmMgr("dev", "", callback, &v8.VmConfig{}, nil)
+	if err != nil {
+		t.Fatalf("create vm mgr err: %v", err)
+	}
+
+	code := `
+(function() {
+	const base = ` + "`" + ts.URL + "`" + `;
+	const report = (name, ok) => v8goGo.sendMessage(0, 0, name + (ok ? ':ok' : ':fail'), '', '', '');
+
+	fetch(base + '/json').then((resp) => {
+		report('json-status', resp.ok && resp.status === 200 && resp.headers.get('content-type') === 'application/json');
+		return resp.json();
+	}).then((data) => {
+		report('json-body', data.id === 1 && data.name === '中文');
+	}).catch((e) => report('json ' + e, false));
+
+	fetch(new Request(base + '/echo', {method: 'post', body: '{"a":1}', headers: {'content-type': 'application/json'}})).then((resp) => {
+		report('post', resp.status === 201 && resp.headers.get('X-Method') === 'POST' && resp.headers.get('x-content-type') === 'application/json');
+		return resp.text();
+	}).then((text) => {
+		report('post-body', text === '{"a":1}');
+	}).catch((e) => report('post ' + e, false));
+
+	const bin = new Uint8Array([0, 1, 0x80, 0xfe, 0xff]);
+	fetch(base + '/echo', {method: 'post', body: bin.buffer}).then((resp) => {
+		report('post-bin', resp.headers.get('x-content-type') === 'application/octet-stream');
+		return resp.arrayBuffer();
+	}).then((buf) => {
+		let b = new Uint8Array(buf);
+		report('post-bin-body', b.length === bin.length && b.every((v, i) => v === bin[i]));
+	}).catch((e) => report('post-bin ' + e, false));
+
+	fetch(base + '/echo', {method: 'post', body: new Blob([bin.subarray(0, 4)])}).then((resp) => resp.arrayBuffer()).then((buf) => {
+		let b = new Uint8Array(buf);
+		report('post-blob', b.length === 4 && b[2] === 0x80 && b[3] === 0xfe);
+	}).catch((e) => report('post-blob ' + e, false));
+
+	const controller = new AbortController();
+	fetch(base + '/slow', {signal: controller.signal}).then(() => {
+		report('abort', false);
+	}).catch((e) => {
+		report('abort', e.name === 'AbortError');
+	});
+	controller.abort();
+
+	fetch('http://127.0.0.1:1/').then(() => report('error', false)).catch((e) => report('error', e instanceof TypeError));
+})();
+`
+	_, err = vmMgr.Execute(0, code, "test.js")
+	if err != nil {
+		t.Fatalf("test fail: %v", err)
+	}
+
+	for i := 0; i < 9; i++ {
+		select {
+		case r := <-results:
+			t.Log(r)
+			if len(r) < 3 || r[len(r)-3:] != ":ok" {
+				t.Errorf("fetch test failed: %s", r)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("fetch test timeout")
+		}
+	}
+}
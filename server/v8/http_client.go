@@ -0,0 +1,100 @@
+package v8
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	DefaultXhrDialTimeout     = 2
+	DefaultXhrHeaderTimeout   = 6
+	DefaultXhrTimeout         = 8
+	DefaultXhrMaxIdleConns    = 200
+	DefaultXhrMaxIdlePerHost  = 100
+	DefaultXhrIdleConnTimeout = 15
+)
+
+// HttpClientConfig configures the client of xhr and fetch. Timeouts are in
+// seconds. Timeout applies to the requests without a timeout of their own,
+// like fetch; xhr.timeout overrides it.
+type HttpClientConfig struct {
+	DialTimeout         int    `toml:"dial_timeout"`
+	HeaderTimeout       int    `toml:"header_timeout"`
+	Timeout             int    `toml:"timeout"`
+	MaxIdleConns        int    `toml:"max_idle_conns"`
+	MaxIdleConnsPerHost int    `toml:"max_idle_conns_per_host"`
+	IdleConnTimeout     int    `toml:"idle_conn_timeout"`
+	Proxy               string `toml:"proxy"`
+	CaFile              string `toml:"ca_file"`
+	CertFile            string `toml:"cert_file"`
+	KeyFile             string `toml:"key_file"`
+}
+
+func newHttpClient(c *HttpClientConfig, policy *OutboundPolicy) (*http.Client, error) {
+	transport := &http.Transport{
+		MaxIdleConns:          orDefault(c.MaxIdleConns, DefaultXhrMaxIdleConns),
+		MaxIdleConnsPerHost:   orDefault(c.MaxIdleConnsPerHost, DefaultXhrMaxIdlePerHost),
+		IdleConnTimeout:       time.Duration(orDefault(c.IdleConnTimeout, DefaultXhrIdleConnTimeout)) * time.Second,
+		DisableCompression:    true,
+		ResponseHeaderTimeout: time.Duration(orDefault(c.HeaderTimeout, DefaultXhrHeaderTimeout)) * time.Second,
+		DialContext: dialContext(&net.Dialer{
+			Timeout:        time.Duration(orDefault(c.DialTimeout, DefaultXhrDialTimeout)) * time.Second,
+			ControlContext: dialControl(policy),
+		}),
+	}
+
+	if c.Proxy != "" {
+		// the proxy resolves the hosts, the dialer would only check its address
+		if policy != nil && len(policy.denied) > 0 {
+			return nil, errors.New("outbound.deny_cidrs can't be used with http_client.proxy")
+		}
+		proxyUrl, err := url.Parse(c.Proxy)
+		if err != nil || proxyUrl.Host == "" {
+			return nil, fmt.Errorf("invalid http_client.proxy: %s", c.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	if c.CaFile != "" || c.CertFile != "" || c.KeyFile != "" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if c.CaFile != "" {
+			pem, err := os.ReadFile(c.CaFile)
+			if err != nil {
+				return nil, err
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate found in http_client.ca_file: %s", c.CaFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if c.CertFile != "" || c.KeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	// the total timeout is set per request, see XmlHttpRequestMgr.Open
+	return &http.Client{Transport: transport}, nil
+}
+
+func orDefault(v int, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
@@ -1,18 +1,24 @@
 package v8
 
 import (
+	"errors"
 	"fmt"
 	"github.com/lizc2003/v8go"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/util"
 	"os"
 	"strings"
+	"sync/atomic"
 )
 
 var gInitJs string
 var gInitJsCache *v8go.CompilerCachedData
 var gServerFileName string
-var gServerJs string
-var gServerJsCache *v8go.CompilerCachedData
+var gServerScript atomic.Pointer[serverScript]
+
+type serverScript struct {
+	js    string
+	cache *v8go.CompilerCachedData
+}
 
 const (
 	gInitJsName   = "init.js"
@@ -44,6 +50,8 @@ func initVm(bDev bool, serverDir string, useStrict bool, heapSizeLimit int32) er
 	}
 	gInitJs = strings.Replace(initJsContent, "$NODE_ENV", nodeEnv, 1)
 	gInitJs += xmlHttpRequestJsContent
+	gInitJs += fetchJsContent
+	gInitJs += timerJsContent
 
 	var err error
 	gInitJsCache, err = CompileJsScript(gInitJs, gInitJsName)
@@ -51,26 +59,53 @@ func initVm(bDev bool, serverDir string, useStrict bool, heapSizeLimit int32) er
 		return err
 	}
 
+	gServerFileName = ""
+	gServerScript.Store(nil)
 	if serverDir != "" {
 		gServerFileName = serverDir + "/" + gServerJsName
-		content, err := os.ReadFile(gServerFileName)
-		if err != nil {
-			return err
-		}
-		serverJs := util.UnsafeBytes2Str(content)
-		serverJsCache, err := CompileJsScript(serverJs, gServerJsName)
+		ss, err := loadServerJs()
 		if err != nil {
 			return err
 		}
-
 		if !bDev {
-			gServerJs = serverJs
-			gServerJsCache = serverJsCache
+			gServerScript.Store(ss)
 		}
 	}
 	return nil
 }
 
+func loadServerJs() (*serverScript, error) {
+	content, err := os.ReadFile(gServerFileName)
+	if err != nil {
+		return nil, err
+	}
+	serverJs := util.UnsafeBytes2Str(content)
+	serverJsCache, err := CompileJsScript(serverJs, gServerJsName)
+	if err != nil {
+		return nil, err
+	}
+	return &serverScript{js: serverJs, cache: serverJsCache}, nil
+}
+
+// tryServerJs runs ss in a trial worker, so that a bundle which throws or
+// doesn't define v8goRenderToString never reaches the pool.
+func tryServerJs(callback SendMessageCallback, ss *serverScript) error {
+	worker, err := newWorker(callback, 0, ss)
+	if err != nil {
+		return err
+	}
+	defer worker.Dispose()
+
+	val, err := worker.v8ctx.RunScript("typeof v8goRenderToString", "check.js")
+	if err != nil {
+		return ToJsError(err)
+	}
+	if val.String() != "function" {
+		return errors.New("v8goRenderToString is not defined by server.js")
+	}
+	return nil
+}
+
 const initJsContent = `
 globalThis.process = { env: { NODE_ENV: '$NODE_ENV' }};
 globalThis.v8goJs = {};
@@ -0,0 +1,565 @@
+// Copyright 2020-present, lizc2003@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v8
+
+const fetchJsContent = `
+(function() {
+	const forbiddenBodyMethods = ['GET', 'HEAD'];
+
+	function utf8Encode(str) {
+		let bytes = [];
+		for (let i = 0; i < str.length; i++) {
+			let c = str.codePointAt(i);
+			if (c > 0xffff) {
+				i++;
+			}
+			if (c < 0x80) {
+				bytes.push(c);
+			} else if (c < 0x800) {
+				bytes.push(0xc0 | (c >> 6), 0x80 | (c & 0x3f));
+			} else if (c < 0x10000) {
+				bytes.push(0xe0 | (c >> 12), 0x80 | ((c >> 6) & 0x3f), 0x80 | (c & 0x3f));
+			} else {
+				bytes.push(0xf0 | (c >> 18), 0x80 | ((c >> 12) & 0x3f), 0x80 | ((c >> 6) & 0x3f), 0x80 | (c & 0x3f));
+			}
+		}
+		return new Uint8Array(bytes);
+	}
+
+	function utf8Decode(bytes) {
+		let str = '';
+		let i = 0;
+		while (i < bytes.length) {
+			let c = bytes[i++];
+			if (c >= 0xf0) {
+				c = ((c & 0x07) << 18) | ((bytes[i++] & 0x3f) << 12) | ((bytes[i++] & 0x3f) << 6) | (bytes[i++] & 0x3f);
+			} else if (c >= 0xe0) {
+				c = ((c & 0x0f) << 12) | ((bytes[i++] & 0x3f) << 6) | (bytes[i++] & 0x3f);
+			} else if (c >= 0xc0) {
+				c = ((c & 0x1f) << 6) | (bytes[i++] & 0x3f);
+			}
+			str += String.fromCodePoint(c);
+		}
+		return str;
+	}
+
+	function bodyToString(body) {
+		if (body === undefined || body === null) {
+			return null;
+		}
+		if (typeof body === 'string') {
+			return body;
+		}
+		return String(body);
+	}
+
+	if (typeof globalThis.DOMException === 'undefined') {
+		globalThis.DOMException = class DOMException extends Error {
+			constructor(message, name) {
+				super(message);
+				this.name = name || 'Error';
+			}
+		};
+	}
+
+	class AbortSignal {
+		constructor() {
+			this.aborted = false;
+			this.reason = undefined;
+			this.onabort = null;
+			this._listeners = [];
+		}
+
+		addEventListener(type, fn) {
+			if (type === 'abort' && typeof fn === 'function') {
+				this._listeners.push(fn);
+			}
+		}
+
+		removeEventListener(type, fn) {
+			if (type === 'abort') {
+				this._listeners = this._listeners.filter((l) => l !== fn);
+			}
+		}
+
+		throwIfAborted() {
+			if (this.aborted) {
+				throw this.reason;
+			}
+		}
+
+		_abort(reason) {
+			if (this.aborted) {
+				return;
+			}
+			this.aborted = true;
+			this.reason = (reason === undefined) ? new DOMException('This operation was aborted', 'AbortError') : reason;
+
+			let evt = {type: 'abort', target: this};
+			if (typeof this.onabort === 'function') {
+				this.onabort.call(this, evt);
+			}
+			let listeners = this._listeners;
+			this._listeners = [];
+			listeners.forEach((l) => l.call(this, evt));
+		}
+
+		static abort(reason) {
+			let signal = new AbortSignal();
+			signal._abort(reason);
+			return signal;
+		}
+
+		static timeout(delay) {
+			let signal = new AbortSignal();
+			setTimeout(() => signal._abort(new DOMException('The operation timed out.', 'TimeoutError')), delay);
+			return signal;
+		}
+	}
+
+	class AbortController {
+		constructor() {
+			this.signal = new AbortSignal();
+		}
+
+		abort(reason) {
+			this.signal._abort(reason);
+		}
+	}
+
+	class Headers {
+		constructor(init) {
+			this._map = new Map();
+			if (init instanceof Headers) {
+				init.forEach((value, name) => this.append(name, value));
+			} else if (Array.isArray(init)) {
+				init.forEach((pair) => {
+					if (pair.length !== 2) {
+						throw new TypeError('Failed to construct \'Headers\': Invalid value');
+					}
+					this.append(pair[0], pair[1]);
+				});
+			} else if (init) {
+				Object.keys(init).forEach((name) => this.append(name, init[name]));
+			}
+		}
+
+		append(name, value) {
+			let key = String(name).toLowerCase();
+			value = String(value).trim();
+			let item = this._map.get(key);
+			if (item) {
+				item.value += ', ' + value;
+			} else {
+				this._map.set(key, {name: String(name), value: value});
+			}
+		}
+
+		set(name, value) {
+			this._map.set(String(name).toLowerCase(), {name: String(name), value: String(value).trim()});
+		}
+
+		get(name) {
+			let item = this._map.get(String(name).toLowerCase());
+			return item ? item.value : null;
+		}
+
+		has(name) {
+			return this._map.has(String(name).toLowerCase());
+		}
+
+		delete(name) {
+			this._map.delete(String(name).toLowerCase());
+		}
+
+		forEach(callback, thisArg) {
+			for (let [key, item] of this.entries()) {
+				callback.call(thisArg, item, key, this);
+			}
+		}
+
+		*entries() {
+			let keys = [...this._map.keys()].sort();
+			for (let key of keys) {
+				yield [key, this._map.get(key).value];
+			}
+		}
+
+		*keys() {
+			for (let [key] of this.entries()) {
+				yield key;
+			}
+		}
+
+		*values() {
+			for (let [, value] of this.entries()) {
+				yield value;
+			}
+		}
+
+		[Symbol.iterator]() {
+			return this.entries();
+		}
+
+		_toObject() {
+			let obj = {};
+			this._map.forEach((item) => {
+				obj[item.name] = item.value;
+			});
+			return obj;
+		}
+	}
+
+	function toBytes(data) {
+		if (data instanceof ArrayBuffer) {
+			return new Uint8Array(data);
+		}
+		if (ArrayBuffer.isView(data)) {
+			return new Uint8Array(data.buffer, data.byteOffset, data.byteLength);
+		}
+		if (data instanceof Blob) {
+			return data._bytes;
+		}
+		return null;
+	}
+
+	class Blob {
+		constructor(parts, options) {
+			let chunks = [];
+			let size = 0;
+			(parts || []).forEach((part) => {
+				let bytes = toBytes(part) || utf8Encode(String(part));
+				chunks.push(bytes);
+				size += bytes.length;
+			});
+			this._bytes = new Uint8Array(size);
+			let offset = 0;
+			chunks.forEach((bytes) => {
+				this._bytes.set(bytes, offset);
+				offset += bytes.length;
+			});
+			this.size = size;
+			this.type = (options && options.type) ? String(options.type).toLowerCase() : '';
+		}
+
+		arrayBuffer() {
+			return Promise.resolve(this._bytes.slice().buffer);
+		}
+
+		bytes() {
+			return Promise.resolve(this._bytes.slice());
+		}
+
+		text() {
+			return Promise.resolve(utf8Decode(this._bytes));
+		}
+
+		slice(start, end, type) {
+			return new Blob([this._bytes.subarray(start, end)], {type: type});
+		}
+	}
+
+	class Body {
+		_initBody(body) {
+			// binary bodies are kept as bytes, so that they survive arrayBuffer()
+			let bytes = toBytes(body);
+			this._bodyBytes = bytes ? bytes.slice() : null;
+			this._bodyText = bytes ? null : bodyToString(body);
+			this.bodyUsed = false;
+		}
+
+		get body() {
+			return this._bodyBytes ? utf8Decode(this._bodyBytes) : this._bodyText;
+		}
+
+		_bodyInit() {
+			return this._bodyBytes ? this._bodyBytes : this._bodyText;
+		}
+
+		_consume(binary) {
+			if (this.bodyUsed) {
+				return Promise.reject(new TypeError('Failed to execute: body stream already read'));
+			}
+			this.bodyUsed = true;
+			if (binary) {
+				return Promise.resolve(this._bodyBytes ? this._bodyBytes.slice() : utf8Encode(this._bodyText === null ? '' : this._bodyText));
+			}
+			return Promise.resolve(this._bodyBytes ? utf8Decode(this._bodyBytes) : (this._bodyText === null ? '' : this._bodyText));
+		}
+
+		text() {
+			return this._consume(false);
+		}
+
+		json() {
+			return this._consume(false).then((text) => JSON.parse(text));
+		}
+
+		arrayBuffer() {
+			return this._consume(true).then((bytes) => bytes.buffer);
+		}
+
+		bytes() {
+			return this._consume(true);
+		}
+
+		blob() {
+			return this._consume(true).then((bytes) => new Blob([bytes], {type: this.headers.get('Content-Type') || ''}));
+		}
+
+		formData() {
+			return Promise.reject(new TypeError('FormData is not supported'));
+		}
+	}
+
+	class Request extends Body {
+		constructor(input, init) {
+			super();
+			init = init || {};
+
+			let body = init.body;
+			if (input instanceof Request) {
+				if (input.bodyUsed) {
+					throw new TypeError('Failed to construct \'Request\': Cannot construct a Request with a Request object that has already been used.');
+				}
+				this.url = input.url;
+				this.method = input.method;
+				this.headers = new Headers(input.headers);
+				this.signal = input.signal;
+				this.credentials = input.credentials;
+				this.mode = input.mode;
+				this.redirect = input.redirect;
+				if (body === undefined && input._bodyInit() !== null) {
+					body = input._bodyInit();
+					input.bodyUsed = true;
+				}
+			} else {
+				this.url = String(input);
+				this.method = 'GET';
+				this.headers = new Headers();
+				this.signal = null;
+				this.credentials = 'same-origin';
+				this.mode = 'cors';
+				this.redirect = 'follow';
+			}
+
+			if (init.method !== undefined) {
+				this.method = String(init.method).toUpperCase();
+			}
+			if (init.headers !== undefined) {
+				this.headers = new Headers(init.headers);
+			}
+			if (init.signal !== undefined) {
+				this.signal = init.signal;
+			}
+			if (init.credentials !== undefined) {
+				this.credentials = init.credentials;
+			}
+			if (init.mode !== undefined) {
+				this.mode = init.mode;
+			}
+			if (init.redirect !== undefined) {
+				this.redirect = init.redirect;
+			}
+
+			if (body !== undefined && body !== null && forbiddenBodyMethods.indexOf(this.method) >= 0) {
+				throw new TypeError('Failed to construct \'Request\': Request with GET/HEAD method cannot have body.');
+			}
+			this._initBody(body);
+			if (typeof body === 'string' && !this.headers.has('Content-Type')) {
+				this.headers.set('Content-Type', 'text/plain;charset=UTF-8');
+			} else if (body instanceof Blob && body.type && !this.headers.has('Content-Type')) {
+				this.headers.set('Content-Type', body.type);
+			}
+		}
+
+		clone() {
+			if (this.bodyUsed) {
+				throw new TypeError('Failed to execute \'clone\' on \'Request\': Request body is already used');
+			}
+			return new Request(this, {body: this._bodyInit()});
+		}
+	}
+
+	class Response extends Body {
+		constructor(body, init) {
+			super();
+			init = init || {};
+			this.status = (init.status === undefined) ? 200 : init.status;
+			if (this.status < 200 || this.status > 599) {
+				throw new RangeError('Failed to construct \'Response\': The status provided (' + this.status + ') is outside the range [200, 599].');
+			}
+			this.statusText = (init.statusText === undefined) ? '' : String(init.statusText);
+			this.headers = new Headers(init.headers);
+			this.ok = this.status >= 200 && this.status < 300;
+			this.type = 'default';
+			this.url = init.url || '';
+			this.redirected = false;
+			this._initBody(body);
+		}
+
+		clone() {
+			if (this.bodyUsed) {
+				throw new TypeError('Failed to execute \'clone\' on \'Response\': Response body is already used');
+			}
+			return new Response(this._bodyInit(), {
+				status: this.status,
+				statusText: this.statusText,
+				headers: this.headers,
+				url: this.url
+			});
+		}
+
+		static error() {
+			let resp = new Response(null, {status: 200});
+			resp.status = 0;
+			resp.ok = false;
+			resp.type = 'error';
+			return resp;
+		}
+
+		static json(data, init) {
+			init = Object.assign({}, init);
+			let headers = new Headers(init.headers);
+			if (!headers.has('Content-Type')) {
+				headers.set('Content-Type', 'application/json');
+			}
+			init.headers = headers;
+			return new Response(JSON.stringify(data), init);
+		}
+
+		static redirect(url, status) {
+			status = status || 302;
+			return new Response(null, {status: status, headers: {Location: String(url)}});
+		}
+	}
+
+	function fetch(input, init) {
+		return new Promise(function(resolve, reject) {
+			let request;
+			try {
+				request = new Request(input, init);
+			} catch (e) {
+				reject(e);
+				return;
+			}
+
+			let signal = request.signal;
+			if (signal && signal.aborted) {
+				reject(signal.reason);
+				return;
+			}
+
+			let xhrId = 0;
+			let status = 0;
+			let responseHeaders = {};
+
+			let onAbort = function() {
+				let tmpId = xhrId;
+				if (tmpId > 0) {
+					xhrId = 0;
+					v8goGo.handleXhrCmd(JSON.stringify({cmd: 'abort', xhr_id: tmpId}));
+					reject(signal.reason);
+				}
+			};
+			let finish = function() {
+				xhrId = 0;
+				if (signal) {
+					signal.removeEventListener('abort', onAbort);
+				}
+			};
+
+			let obj = {
+				_onStartCallback: function() {},
+
+				_onHeaderCallback: function(_status, _headers) {
+					status = _status;
+					responseHeaders = _headers || {};
+				},
+
+				_onAbortCallback: function() {
+					if (xhrId > 0) {
+						finish();
+						reject(new DOMException('The render was closed', 'AbortError'));
+					}
+				},
+
+				_onErrorCallback: function(err) {
+					if (xhrId > 0) {
+						finish();
+						reject(new TypeError('Failed to fetch: ' + err));
+					}
+				},
+
+				_onTimeoutCallback: function(err) {
+					this._onErrorCallback(err);
+				},
+
+				_onEndCallback: function(response) {
+					if (xhrId > 0) {
+						finish();
+						let resp = new Response(response, {
+							status: 200,
+							statusText: v8goJs.xhrMgr.getStatusText(status),
+							headers: responseHeaders,
+							url: request.url
+						});
+						resp.status = status;
+						resp.ok = status >= 200 && status < 300;
+						resolve(resp);
+					}
+				}
+			};
+
+			let options = {
+				cmd: 'open',
+				url: request.url,
+				method: request.method,
+				headers: request.headers._toObject(),
+				timeout: 0,
+				response_type: 'auto'
+			};
+			// binary bodies are sent in base64, a js string can't carry raw bytes
+			if (request._bodyBytes !== null) {
+				if (request._bodyBytes.length > 0) {
+					options.post = v8goJs.xhrMgr.encodeBase64(request._bodyBytes);
+					options.post_encoding = 'base64';
+				}
+			} else if (request._bodyText !== null && request._bodyText.length > 0) {
+				options.post = request._bodyText;
+			}
+
+			xhrId = parseInt(v8goGo.handleXhrCmd(JSON.stringify(options)));
+			if (xhrId > 0) {
+				v8goJs.xhrMgr.addObject(xhrId, obj);
+				if (signal) {
+					signal.addEventListener('abort', onAbort);
+				}
+			} else {
+				xhrId = 0;
+				reject(new TypeError('Failed to fetch: ' + request.url));
+			}
+		});
+	}
+
+	globalThis.AbortSignal = AbortSignal;
+	globalThis.AbortController = AbortController;
+	globalThis.Blob = Blob;
+	globalThis.Headers = Headers;
+	globalThis.Request = Request;
+	globalThis.Response = Response;
+	globalThis.fetch = fetch;
+})();
+`
@@ -0,0 +1,89 @@
+// Copyright 2020-present, lizc2003@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v8
+
+const timerJsContent = `
+v8goJs.timerMgr = (function() {
+	const timerMap = new Map();
+
+	function addTimer(callback, delay, args, repeat) {
+		if (typeof callback !== 'function') {
+			throw new TypeError('The "callback" argument must be of type function.');
+		}
+		delay = Number(delay);
+		if (!(delay > 0)) {
+			delay = 0;
+		}
+
+		let timerId = parseInt(v8goGo.setTimer(Math.floor(delay), repeat));
+		timerMap.set(timerId, {callback: callback, args: args, repeat: repeat});
+		return timerId;
+	}
+
+	function clearTimer(timerId) {
+		timerId = parseInt(timerId);
+		if (timerMap.delete(timerId)) {
+			v8goGo.clearTimer(timerId);
+		}
+	}
+
+	return {
+		addTimer: addTimer,
+		clearTimer: clearTimer,
+
+		sendEvent: function (msg) {
+			if (msg.event === "ontimer") {
+				let t = timerMap.get(msg.timer_id);
+				if (t === undefined) {
+					return;
+				}
+				if (!t.repeat) {
+					timerMap.delete(msg.timer_id);
+				}
+				t.callback.apply(globalThis, t.args);
+			} else if (msg.event === "onclear") {
+				msg.timer_ids.forEach((timerId) => timerMap.delete(timerId));
+			}
+		}
+	};
+})();
+
+globalThis.setTimeout = function(callback, delay, ...args) {
+	return v8goJs.timerMgr.addTimer(callback, delay, args, false);
+};
+
+globalThis.setInterval = function(callback, delay, ...args) {
+	return v8goJs.timerMgr.addTimer(callback, delay, args, true);
+};
+
+globalThis.setImmediate = function(callback, ...args) {
+	return v8goJs.timerMgr.addTimer(callback, 0, args, false);
+};
+
+globalThis.clearTimeout = v8goJs.timerMgr.clearTimer;
+globalThis.clearInterval = v8goJs.timerMgr.clearTimer;
+globalThis.clearImmediate = v8goJs.timerMgr.clearTimer;
+
+if (typeof globalThis.queueMicrotask !== 'function') {
+	globalThis.queueMicrotask = function(callback) {
+		if (typeof callback !== 'function') {
+			throw new TypeError('The "callback" argument must be of type function.');
+		}
+		Promise.resolve().then(callback).catch((err) => {
+			console.error(err && err.stack ? err.stack : err);
+		});
+	};
+}
+`
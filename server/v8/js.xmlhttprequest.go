@@ -20,8 +20,53 @@ v8goJs.xhrMgr = (function() {
 	const statusCodes = {
 		100:'Continue',101:'Switching Protocols',102:'Processing',200:'OK',201:'Created',202:'Accepted',203:'Non-Authoritative Information',204:'No Content',205:'Reset Content',206:'Partial Content',207:'Multi-Status',208:'Already Reported',226:'IM Used',300:'Multiple Choices',301:'Moved Permanently',302:'Found',303:'See Other',304:'Not Modified',305:'Use Proxy',306:'Switch Proxy',307:'Temporary Redirect',308:'Permanent Redirect',400:'Bad Request',401:'Unauthorized',402:'Payment Required',403:'Forbidden',404:'Not Found',405:'Method Not Allowed',406:'Not Acceptable',407:'Proxy Authentication Required',408:'Request Timeout',409:'Conflict',410:'Gone',411:'Length Required',412:'Precondition Failed',413:'Request Entity Too Large',414:'Request-URI Too Long',415:'Unsupported Media Type',416:'Requested Range Not Satisfiable',417:'Expectation Failed',418:'I\'m a teapot',419:'Authentication Timeout',420:'Method Failure',420:'Enhance Your Calm',422:'Unprocessable Entity',423:'Locked',424:'Failed Dependency',426:'Upgrade Required',428:'Precondition Required',429:'Too Many Requests',431:'Request Header Fields Too Large',440:'Login Timeout',444:'No Response',449:'Retry With',450:'Blocked by Windows Parental Controls',451:'Unavailable For Legal Reasons',451:'Redirect',494:'Request Header Too Large',495:'Cert Error',496:'No Cert',497:'HTTP to HTTPS',498:'Token expired/invalid',499:'Client Closed Request',499:'Token required',500:'Internal Server Error',501:'Not Implemented',502:'Bad Gateway',503:'Service Unavailable',504:'Gateway Timeout',505:'HTTP Version Not Supported',506:'Variant Also Negotiates',507:'Insufficient Storage',508:'Loop Detected',509:'Bandwidth Limit Exceeded',510:'Not Extended',511:'Network Authentication Required',520:'Origin Error',521:'Web server is down',522:'Connection timed out',523:'Proxy Declined Request',524:'A timeout occurred',598:'Network read timeout error',599:'Network connect timeout error'
 	};
+	const base64Chars = 'ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/';
+	const base64Codes = new Uint8Array(128);
+	base64Chars.split('').forEach(function(c, i) {
+		base64Codes[c.charCodeAt(0)] = i;
+	});
+
+	function encodeBase64(bytes) {
+		let len = bytes.length;
+		let chars = [];
+		for (let i = 0; i < len; i += 3) {
+			let n = (bytes[i] << 16) | ((i + 1 < len ? bytes[i + 1] : 0) << 8) | (i + 2 < len ? bytes[i + 2] : 0);
+			chars.push(base64Chars[(n >> 18) & 0x3f], base64Chars[(n >> 12) & 0x3f],
+				i + 1 < len ? base64Chars[(n >> 6) & 0x3f] : '=', i + 2 < len ? base64Chars[n & 0x3f] : '=');
+		}
+		return chars.join('');
+	}
+
+	function decodeBase64(str) {
+		let len = str.length;
+		while (len > 0 && str[len - 1] === '=') {
+			len--;
+		}
+		let bytes = new Uint8Array((len * 3) >> 2);
+		let j = 0;
+		for (let i = 0; i < len; i += 4) {
+			let n = (base64Codes[str.charCodeAt(i)] << 18) | (base64Codes[str.charCodeAt(i + 1)] << 12);
+			if (i + 2 < len) {
+				n |= base64Codes[str.charCodeAt(i + 2)] << 6;
+			}
+			if (i + 3 < len) {
+				n |= base64Codes[str.charCodeAt(i + 3)];
+			}
+			bytes[j++] = n >> 16;
+			if (j < bytes.length) {
+				bytes[j++] = (n >> 8) & 0xff;
+			}
+			if (j < bytes.length) {
+				bytes[j++] = n & 0xff;
+			}
+		}
+		return bytes;
+	}
 
 	return {
+		decodeBase64: decodeBase64,
+		encodeBase64: encodeBase64,
+
 		getStatusText: function (status) {
 			if (statusCodes.hasOwnProperty(status)) {
 				return statusCodes[status];
@@ -43,14 +88,23 @@ v8goJs.xhrMgr = (function() {
 		
 			if (evt === "onfinish") {
 				xhrMap.delete(msg.xhr_id);
+			} else if (evt === "onabort") {
+				xhrMap.delete(msg.xhr_id);
+				obj._onAbortCallback()
 			} else if (evt === "onerror") {
 				obj._onErrorCallback(msg.error)
+			} else if (evt === "ontimeout") {
+				obj._onTimeoutCallback(msg.error)
 			} else if (evt === "onstart") {
 				obj._onStartCallback()
 			} else if (evt === "onheader") {
 				obj._onHeaderCallback(msg.status, msg.headers)
 			} else if (evt === "onend") {
-				obj._onEndCallback(msg.response)
+				let response = msg.response === undefined ? '' : msg.response;
+				if (msg.encoding === "base64") {
+					response = decodeBase64(response);
+				}
+				obj._onEndCallback(response)
 			}
 		}
 	};
@@ -59,7 +113,7 @@ v8goJs.xhrMgr = (function() {
 globalThis.XMLHttpRequest = function() {
 	let method, url,
 		xhr, callEventListeners,
-		listeners = ['readystatechange', 'abort', 'error', 'loadend', 'progress', 'load'],
+		listeners = ['readystatechange', 'abort', 'error', 'loadend', 'progress', 'load', 'timeout'],
 		privateListeners = {},
 		responseHeaders = {},
 		headers = {},
@@ -100,8 +154,9 @@ globalThis.XMLHttpRequest = function() {
 		statusText: '',
 		responseText: '',
 		response: '',
+		responseType: '',
 		readyState: 0,
-		timeout: 8000,
+		timeout: 0,
 
 		open: function(_method, _url, _async, _user, _password) {
 			let async = (typeof _async !== "boolean" ? true : _async)
@@ -137,7 +192,8 @@ globalThis.XMLHttpRequest = function() {
 				url: url,
 				method: method,
 				headers: headers,
-				timeout: xhr.timeout
+				timeout: xhr.timeout,
+				response_type: xhr.responseType
 			};
  
 			if (post) {
@@ -271,12 +327,42 @@ globalThis.XMLHttpRequest = function() {
 			}
 		},
 
+		_onTimeoutCallback: function(err) {
+			if (thisXhrId > 0) {
+				thisXhrId = 0;
+				if (callEventListeners('timeout') === false) {
+					console.error(err);
+				}
+				callEventListeners('loadend');
+			}
+		},
+
+		_onAbortCallback: function() {
+			if (thisXhrId > 0) {
+				thisXhrId = 0;
+				callEventListeners(['abort', 'loadend']);
+			}
+		},
+
 		_onEndCallback: function(response) {
 			if (thisXhrId > 0) {
 				thisXhrId = 0;
 				xhr.readyState = xhr.DONE;
-				xhr.responseText = response;
-				xhr.response = response;
+				// binary responses arrive as Uint8Array, see decodeBase64
+				if (xhr.responseType === 'arraybuffer') {
+					xhr.response = response.buffer;
+				} else if (xhr.responseType === 'blob') {
+					xhr.response = new Blob([response], {type: xhr.getResponseHeader('Content-Type') || ''});
+				} else if (xhr.responseType === 'json') {
+					try {
+						xhr.response = JSON.parse(response);
+					} catch (e) {
+						xhr.response = null;
+					}
+				} else {
+					xhr.responseText = response;
+					xhr.response = response;
+				}
 				callEventListeners(['readystatechange', 'load', 'loadend']);
 			}
 		}
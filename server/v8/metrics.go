@@ -0,0 +1,60 @@
+package v8
+
+import (
+	"github.com/lizc2003/vue-ssr-v8go/server/common/metrics"
+	"sync/atomic"
+)
+
+var (
+	metricVmAcquireWait = metrics.NewHistogramVec("vssr_vm_acquire_wait_seconds",
+		"Time spent waiting for a v8 instance.", metrics.DefBuckets)
+	metricVmAcquireTimeouts = metrics.NewCounterVec("vssr_vm_acquire_timeouts_total",
+		"Number of times no v8 instance could be acquired.")
+	metricWorkerHeapUsed = metrics.NewGaugeVec("vssr_worker_heap_used_bytes",
+		"Used heap size of a v8 worker, sampled by the heap check.", "worker")
+	metricWorkerHeapTotal = metrics.NewGaugeVec("vssr_worker_heap_total_bytes",
+		"Total heap size of a v8 worker, sampled by the heap check.", "worker")
+	metricWorkerGc = metrics.NewCounterVec("vssr_worker_gc_total",
+		"Number of full gc triggered by the heap check.")
+	metricXhrDuration = metrics.NewHistogramVec("vssr_xhr_duration_seconds",
+		"Total time of xhr requests made during render.", metrics.DefBuckets)
+	metricXhrQueueWait = metrics.NewHistogramVec("vssr_xhr_queue_wait_seconds",
+		"Time xhr requests wait in the queue before being performed.", metrics.DefBuckets)
+	metricXhrErrors = metrics.NewCounterVec("vssr_xhr_errors_total",
+		"Number of xhr requests that ended with an error.")
+	metricXhrDenied = metrics.NewCounterVec("vssr_xhr_denied_total",
+		"Number of xhr requests denied by the outbound policy.")
+	metricXhrCache = metrics.NewCounterVec("vssr_xhr_cache_requests_total",
+		"Number of xhr cache lookups by result.", "result")
+)
+
+func init() {
+	metrics.NewGaugeFunc("vssr_vm_instances", "Current number of v8 instances.", func() float64 {
+		if ThisVmMgr == nil {
+			return 0
+		}
+		return float64(atomic.LoadInt32(&ThisVmMgr.vmCurrentInstances))
+	})
+	metrics.NewGaugeFunc("vssr_vm_max_instances", "Maximum number of v8 instances.", func() float64 {
+		if ThisVmMgr == nil {
+			return 0
+		}
+		return float64(atomic.LoadInt32(&ThisVmMgr.vmMaxInstances))
+	})
+	metrics.NewGaugeFunc("vssr_xhr_queue_depth", "Number of xhr requests waiting in the queue.", func() float64 {
+		if ThisVmMgr == nil {
+			return 0
+		}
+		return float64(len(ThisVmMgr.xhrMgr.queue))
+	})
+	metrics.NewGaugeFunc("vssr_xhr_pending", "Number of xhr requests queued or in flight.", func() float64 {
+		if ThisVmMgr == nil {
+			return 0
+		}
+		xhrMgr := ThisVmMgr.xhrMgr
+		xhrMgr.mutex.Lock()
+		n := len(xhrMgr.reqs)
+		xhrMgr.mutex.Unlock()
+		return float64(n)
+	})
+}
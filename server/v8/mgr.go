@@ -1,14 +1,17 @@
 package v8
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/lizc2003/v8go"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/alarm"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/defs"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/trace"
 	"math/rand"
 	"path"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -21,7 +24,9 @@ const (
 	MaxXhrThreads  = 2000
 	MinXhrThreads  = 2
 
-	VmAcquireTimeout     = 5 // seconds
+	VmAcquireTimeout     = 5  // seconds
+	VmWarmInterval       = 1  // seconds
+	VmWarmAheadTime      = 10 // seconds
 	ProcessExitThreshold = 1000
 
 	CheckHeapInterval  = 5 // seconds
@@ -30,22 +35,35 @@ const (
 	MinHeapSizeLimit   = (CheckHeapSize / 1024 / 1024) * 150 / 100
 )
 
-var ErrorNoVm = errors.New("the v8 instance cannot be acquired.")
+var (
+	ErrorNoVm         = errors.New("the v8 instance cannot be acquired.")
+	ErrorVmNotFound   = errors.New("the v8 instance is not found.")
+	ErrorVmBusy       = errors.New("the v8 instance is busy.")
+	ErrorMaxInstances = fmt.Errorf("max instances must be between %d and %d.", MinVmInstances, MaxVmInstances)
+)
 
 type VmConfig struct {
 	UseStrict        bool  `toml:"use_strict"`
+	MinIdleInstances int32 `toml:"min_idle_instances"`
 	HeapSizeLimit    int32 `toml:"heap_size_limit"`
 	MaxInstances     int32 `toml:"max_instances"`
 	InstanceLifetime int32 `toml:"instance_lifetime"`
 	DeleteDelayTime  int32 `toml:"delete_delay_time"`
 	XhrThreads       int32 `toml:"xmlhttprequest_threads"`
+
+	HttpClient HttpClientConfig `toml:"http_client"`
+	Outbound   OutboundConfig   `toml:"outbound"`
+	XhrCache   XhrCacheConfig   `toml:"xhr_cache"`
 }
 
 type VmMgr struct {
 	callback SendMessageCallback
 	xhrMgr   *XmlHttpRequestMgr
+	timerMgr *TimerMgr
 	workers  chan *Worker
 
+	allWorkers map[int64]*Worker
+
 	bDev               bool
 	mutex              sync.Mutex
 	vmDeleteDelayTime  time.Duration
@@ -54,14 +72,22 @@ type VmMgr struct {
 	vmMaxInstances     int32
 	vmCurrentInstances int32
 	vmAcquireFailCount int32
-
-	DumpHeapDir string
-	isDumpHeap  int32
+	vmMinIdle          int32
+	vmReplacing        int32
+	generation         int64
+	reloadMutex        sync.Mutex
+	warmCh             chan struct{}
+	isReady            int32
+
+	DumpHeapDir      string
+	ForwardSetCookie bool
+	isDumpHeap       int32
+	renderTraces     sync.Map
 }
 
 var ThisVmMgr *VmMgr
 
-func NewVmMgr(env string, serverDir string, callback SendMessageCallback, vc *VmConfig, originRewrite *OriginRewrite) (*VmMgr, error) {
+func NewVmMgr(env string, serverDir string, callback SendMessageCallback, vc *VmConfig, rewriteRules []RewriteRule) (*VmMgr, error) {
 	bDev := false
 	if env == defs.EnvDev {
 		bDev = true
@@ -79,7 +105,7 @@ func NewVmMgr(env string, serverDir string, callback SendMessageCallback, vc *Vm
 	}
 	tlog.Infof("v8 version: %s, heap size limit: %dM", v8go.Version(), heapSizeLimit/1024/1024)
 
-	xhrMgr, err := NewXmlHttpRequestMgr(vc.XhrThreads, originRewrite)
+	xhrMgr, err := NewXmlHttpRequestMgr(vc, rewriteRules)
 	if err != nil {
 		return nil, err
 	}
@@ -103,16 +129,37 @@ func NewVmMgr(env string, serverDir string, callback SendMessageCallback, vc *Vm
 		vmDeleteDelayTime = 1
 	}
 
-	workers := make(chan *Worker, vmMaxInstances+100)
+	vmMinIdle := vc.MinIdleInstances
+	if vmMinIdle < 0 {
+		vmMinIdle = 0
+	} else if vmMinIdle > vmMaxInstances {
+		vmMinIdle = vmMaxInstances
+	}
+	if vmMinIdle > 0 && vmLifetime == 0 {
+		tlog.Info("min_idle_instances is ignored as instance_lifetime is 0")
+		vmMinIdle = 0
+	}
+
+	workers := make(chan *Worker, MaxVmInstances+100)
 	ThisVmMgr = &VmMgr{
 		callback:           callback,
 		xhrMgr:             xhrMgr,
+		timerMgr:           NewTimerMgr(),
 		workers:            workers,
+		allWorkers:         make(map[int64]*Worker),
 		bDev:               bDev,
 		vmLifetime:         int64(vmLifetime),
 		vmMaxInstances:     vmMaxInstances,
 		vmDeleteDelayTime:  time.Duration(vmDeleteDelayTime) * time.Second,
 		vmCurrentInstances: 0,
+		vmMinIdle:          vmMinIdle,
+		warmCh:             make(chan struct{}, 1),
+	}
+
+	if vmMinIdle > 0 {
+		go ThisVmMgr.runWarmRoutine()
+	} else {
+		ThisVmMgr.isReady = 1
 	}
 
 	return ThisVmMgr, nil
@@ -122,27 +169,91 @@ func (this *VmMgr) SignalDumpHeap() {
 	atomic.StoreInt32(&this.isDumpHeap, 1)
 }
 
-func (this *VmMgr) Execute(code string, scriptName string) (int64, error) {
+// CloseRender releases the per-render resources once a render has finished,
+// timed out or been cancelled: queued events are dropped, timers are cleared
+// and in-flight xhrs are aborted.
+func (this *VmMgr) CloseRender(renderId int64) {
+	if renderId <= 0 {
+		return
+	}
+	this.renderTraces.Delete(renderId)
+
+	this.mutex.Lock()
+	workers := make([]*Worker, 0, len(this.allWorkers))
+	for _, w := range this.allWorkers {
+		workers = append(workers, w)
+	}
+	this.mutex.Unlock()
+
+	// abort first, so that no event of the render is queued after the drop
+	reqs := this.xhrMgr.AbortRender(renderId)
+	this.timerMgr.CloseRender(renderId)
+	for _, w := range workers {
+		w.dropRenderEvents(renderId)
+	}
+
+	for _, req := range reqs {
+		tlog.Infof("xhr %d-%d aborted by render close", renderId, req.XhrId)
+		req.worker.sendEvent(&xhrEvent{XhrId: req.XhrId, Event: "onabort", renderId: renderId})
+	}
+}
+
+type ExecuteStat struct {
+	WorkerId    int64
+	AcquireWait time.Duration
+}
+
+func (this *VmMgr) Execute(renderId int64, code string, scriptName string) (int64, error) {
+	stat, err := this.ExecuteWithStat(context.Background(), renderId, code, scriptName)
+	return stat.WorkerId, err
+}
+
+// ExecuteWithStat is Execute which also reports how long it waited for a
+// worker. When ctx holds a trace span, the acquire and the execution are
+// traced, and so are the xhrs of the render until CloseRender.
+func (this *VmMgr) ExecuteWithStat(ctx context.Context, renderId int64, code string, scriptName string) (ExecuteStat, error) {
+	var stat ExecuteStat
+	if span := trace.SpanFromContext(ctx); span != nil && renderId > 0 {
+		this.renderTraces.Store(renderId, span.SpanContext())
+	}
+
+	_, acquireSpan := trace.Start(ctx, "vm.acquire", trace.SpanKindInternal)
+	acquireTime := time.Now()
 	w := this.acquireWorker()
+	stat.AcquireWait = time.Since(acquireTime)
+	metricVmAcquireWait.Observe(stat.AcquireWait.Seconds())
 
 	if w == nil {
+		acquireSpan.SetError(ErrorNoVm)
+		acquireSpan.End()
+		metricVmAcquireTimeouts.Inc()
 		errMsg := ErrorNoVm.Error()
 		tlog.Error(errMsg)
 		alarm.SendAlert(errMsg)
-		return 0, ErrorNoVm
+		return stat, ErrorNoVm
+	}
+	acquireSpan.SetAttr("vm.worker_id", w.Id)
+	acquireSpan.End()
+
+	// the render may have been cancelled while waiting for the vm
+	if err := ctx.Err(); err != nil {
+		this.releaseWorker(w)
+		return stat, err
 	}
-	workerId := w.Id
-	err := w.Execute(code, scriptName)
+
+	stat.WorkerId = w.Id
+	_, execSpan := trace.Start(ctx, "vm.execute", trace.SpanKindInternal)
+	execSpan.SetAttr("vm.worker_id", w.Id)
+	err := w.Execute(renderId, code, scriptName)
+	execSpan.SetError(err)
+	execSpan.End()
 
 	// tlog.Debug(w.Execute(`console.debug(dumpObject(globalThis))`, "test.js"))
 
 	bChecked := w.CheckHeap()
 	if (bChecked && this.bDev) ||
 		atomic.CompareAndSwapInt32(&this.isDumpHeap, 1, 0) {
-		n := rand.Int31n(1000)
-		fName := time.Now().Format("20060102-150405") + fmt.Sprintf("-%03d.heapsnapshot", n)
-		fName = path.Join(this.DumpHeapDir, fName)
-		w.isolate.WriteSnapshot(fName, true)
+		this.writeHeapSnapshot(w)
 	}
 
 	this.releaseWorker(w)
@@ -150,7 +261,7 @@ func (this *VmMgr) Execute(code string, scriptName string) (int64, error) {
 	if err != nil {
 		tlog.Error(err)
 	}
-	return workerId, err
+	return stat, err
 }
 
 func (this *VmMgr) acquireWorker() *Worker {
@@ -163,38 +274,32 @@ func (this *VmMgr) acquireWorker() *Worker {
 		select {
 		case worker := <-this.workers:
 			if worker.Acquire() {
-				ret = worker
+				if this.isRetired(worker) {
+					worker.Release()
+					this.retireWorker(worker)
+				} else {
+					ret = worker
+				}
 			} else {
 				busyWorkers = append(busyWorkers, worker)
 			}
 		default:
-			bReachMax = true
-			if this.vmCurrentInstances < this.vmMaxInstances {
-				this.mutex.Lock()
-				if this.vmCurrentInstances < this.vmMaxInstances {
-					atomic.AddInt32(&this.vmCurrentInstances, 1)
-					bReachMax = false
-				}
-				this.mutex.Unlock()
-			}
-
-			if !bReachMax {
-				workerId := atomic.AddInt64(&this.vmMaxId, 1)
-				worker, err := NewWorker(this.callback, workerId)
-				if err == nil {
-					tlog.Infof("vm created: %d", workerId)
-					worker.SetExpireTime(time.Now().Unix() + this.vmLifetime)
-					worker.Acquire()
-					ret = worker
-				} else {
-					tlog.Error(err)
-					atomic.AddInt32(&this.vmCurrentInstances, -1)
-					bNewFailed = true
-				}
+			worker, err := this.newWorker(false)
+			if worker != nil {
+				worker.Acquire()
+				ret = worker
+			} else if err != nil {
+				tlog.Error(err)
+				bNewFailed = true
+			} else {
+				bReachMax = true
 			}
 		}
 
 		if ret != nil || bNewFailed {
+			if ret != nil {
+				this.signalWarm()
+			}
 			for _, w := range busyWorkers {
 				this.workers <- w
 			}
@@ -229,16 +334,327 @@ func (this *VmMgr) releaseWorker(worker *Worker) {
 	if worker != nil {
 		worker.Release()
 
-		if time.Now().Unix() >= worker.GetExpireTime() {
-			atomic.AddInt32(&this.vmCurrentInstances, -1)
-
-			go func(w *Worker) {
-				time.Sleep(this.vmDeleteDelayTime)
-				w.Dispose()
-				tlog.Infof("vm deleted: %d", w.Id)
-			}(worker)
+		if this.isRetired(worker) {
+			this.retireWorker(worker)
 		} else {
 			this.workers <- worker
 		}
 	}
 }
+
+func (this *VmMgr) isRetired(worker *Worker) bool {
+	return time.Now().Unix() >= worker.GetExpireTime() ||
+		worker.generation != atomic.LoadInt64(&this.generation) ||
+		atomic.LoadInt32(&this.vmCurrentInstances)-atomic.LoadInt32(&this.vmReplacing) > atomic.LoadInt32(&this.vmMaxInstances)
+}
+
+// newWorker creates a worker and counts it in the pool. Unless bForce is
+// set, it returns nil without error when the pool is full.
+func (this *VmMgr) newWorker(bForce bool) (*Worker, error) {
+	this.mutex.Lock()
+	if !bForce && atomic.LoadInt32(&this.vmCurrentInstances) >= atomic.LoadInt32(&this.vmMaxInstances) {
+		this.mutex.Unlock()
+		return nil, nil
+	}
+	atomic.AddInt32(&this.vmCurrentInstances, 1)
+	this.mutex.Unlock()
+
+	workerId := atomic.AddInt64(&this.vmMaxId, 1)
+	generation := atomic.LoadInt64(&this.generation)
+	worker, err := NewWorker(this.callback, workerId)
+	if err != nil {
+		atomic.AddInt32(&this.vmCurrentInstances, -1)
+		return nil, err
+	}
+	worker.generation = generation
+	tlog.Infof("vm created: %d", workerId)
+
+	this.mutex.Lock()
+	this.allWorkers[workerId] = worker
+	this.mutex.Unlock()
+	worker.SetExpireTime(time.Now().Unix() + this.vmLifetime)
+	return worker, nil
+}
+
+// retireWorker removes the worker from the pool. It is disposed after
+// vmDeleteDelayTime so that the events of its pending renders can drain.
+func (this *VmMgr) retireWorker(worker *Worker) {
+	if atomic.LoadInt32(&worker.replaced) == 1 {
+		atomic.AddInt32(&this.vmReplacing, -1)
+	}
+	atomic.AddInt32(&this.vmCurrentInstances, -1)
+	this.mutex.Lock()
+	delete(this.allWorkers, worker.Id)
+	this.mutex.Unlock()
+
+	go func(w *Worker) {
+		time.Sleep(this.vmDeleteDelayTime)
+		w.Dispose()
+		tlog.Infof("vm deleted: %d", w.Id)
+	}(worker)
+}
+
+// IsReady reports whether the warm pool has been created.
+func (this *VmMgr) IsReady() bool {
+	return atomic.LoadInt32(&this.isReady) == 1
+}
+
+func (this *VmMgr) signalWarm() {
+	if this.vmMinIdle > 0 {
+		select {
+		case this.warmCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (this *VmMgr) runWarmRoutine() {
+	ticker := time.NewTicker(VmWarmInterval * time.Second)
+	defer ticker.Stop()
+	for {
+		this.warm()
+		select {
+		case <-this.warmCh:
+		case <-ticker.C:
+		}
+	}
+}
+
+// warm creates replacements for the workers about to expire, so that they
+// are ready before the old ones are retired, and keeps at least vmMinIdle
+// workers idle.
+func (this *VmMgr) warm() {
+	// retire the idle workers that have expired, instead of waiting for
+	// acquireWorker to come across them.
+LOOP:
+	for n := len(this.workers); n > 0; n-- {
+		select {
+		case w := <-this.workers:
+			if this.isRetired(w) && w.Acquire() {
+				w.Release()
+				this.retireWorker(w)
+			} else {
+				this.workers <- w
+			}
+		default:
+			break LOOP
+		}
+	}
+
+	now := time.Now().Unix()
+	aheadTime := min(VmWarmAheadTime, this.vmLifetime/2)
+	generation := atomic.LoadInt64(&this.generation)
+
+	var expiring []*Worker
+	idle := int32(0)
+	this.mutex.Lock()
+	for _, w := range this.allWorkers {
+		if w.generation != generation || atomic.LoadInt32(&w.replaced) == 1 {
+			continue
+		}
+		if w.GetExpireTime()-now <= aheadTime {
+			expiring = append(expiring, w)
+		} else if !w.isBusy() {
+			idle++
+		}
+	}
+	this.mutex.Unlock()
+
+	for _, w := range expiring {
+		nw, err := this.newWorker(true)
+		if err != nil {
+			tlog.Error(err)
+			return
+		}
+		atomic.StoreInt32(&w.replaced, 1)
+		atomic.AddInt32(&this.vmReplacing, 1)
+		this.workers <- nw
+		tlog.Infof("vm %d will be replaced by %d", w.Id, nw.Id)
+	}
+
+	for ; idle < this.vmMinIdle; idle++ {
+		nw, err := this.newWorker(false)
+		if nw == nil {
+			if err != nil {
+				tlog.Error(err)
+			}
+			break
+		}
+		this.workers <- nw
+	}
+
+	if idle >= this.vmMinIdle && atomic.CompareAndSwapInt32(&this.isReady, 0, 1) {
+		tlog.Infof("vm pool is ready, idle instances: %d", idle)
+	}
+}
+
+// Reload recompiles server.js and starts a new generation of workers.
+// A bundle that fails to compile or run is refused and the current
+// generation keeps serving.
+func (this *VmMgr) Reload() error {
+	this.reloadMutex.Lock()
+	defer this.reloadMutex.Unlock()
+
+	if gServerFileName != "" {
+		ss, err := loadServerJs()
+		if err != nil {
+			return err
+		}
+		err = tryServerJs(this.callback, ss)
+		if err != nil {
+			return err
+		}
+		if !this.bDev {
+			gServerScript.Store(ss)
+		}
+	}
+	this.recycle()
+	return nil
+}
+
+// PurgeXhrCache empties the xhr cache. It returns false when the cache is
+// disabled.
+func (this *VmMgr) PurgeXhrCache() bool {
+	if this.xhrMgr.cache == nil {
+		return false
+	}
+	this.xhrMgr.cache.Purge()
+	return true
+}
+
+// Recycle starts a new generation of workers with the current server.js.
+func (this *VmMgr) Recycle() {
+	this.reloadMutex.Lock()
+	defer this.reloadMutex.Unlock()
+	this.recycle()
+}
+
+// recycle bumps the generation: idle workers are retired at once and busy
+// ones when they are released.
+func (this *VmMgr) recycle() {
+	generation := atomic.AddInt64(&this.generation, 1)
+
+	var idleWorkers []*Worker
+	bDrained := false
+	for !bDrained {
+		select {
+		case w := <-this.workers:
+			idleWorkers = append(idleWorkers, w)
+		default:
+			bDrained = true
+		}
+	}
+	retired := 0
+	for _, w := range idleWorkers {
+		if w.Acquire() {
+			w.Release()
+			this.retireWorker(w)
+			retired++
+		} else {
+			this.workers <- w
+		}
+	}
+
+	tlog.Infof("vm recycled, generation: %d, retired idle instances: %d", generation, retired)
+	this.signalWarm()
+}
+
+func (this *VmMgr) Workers() []WorkerInfo {
+	this.mutex.Lock()
+	workers := make([]*Worker, 0, len(this.allWorkers))
+	for _, w := range this.allWorkers {
+		workers = append(workers, w)
+	}
+	this.mutex.Unlock()
+
+	infos := make([]WorkerInfo, 0, len(workers))
+	for _, w := range workers {
+		infos = append(infos, w.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Id < infos[j].Id
+	})
+	return infos
+}
+
+func (this *VmMgr) GetMaxInstances() int32 {
+	return atomic.LoadInt32(&this.vmMaxInstances)
+}
+
+func (this *VmMgr) GetCurrentInstances() int32 {
+	return atomic.LoadInt32(&this.vmCurrentInstances)
+}
+
+// SetMaxInstances changes the pool size at runtime. When it shrinks, the
+// surplus workers are retired as they are released.
+func (this *VmMgr) SetMaxInstances(n int32) error {
+	if n < MinVmInstances || n > MaxVmInstances {
+		return ErrorMaxInstances
+	}
+	this.mutex.Lock()
+	old := atomic.SwapInt32(&this.vmMaxInstances, n)
+	this.mutex.Unlock()
+	tlog.Infof("vm max instances changed: %d -> %d", old, n)
+	return nil
+}
+
+// DumpHeap writes a heap snapshot of the worker once it is idle and
+// returns the file name.
+func (this *VmMgr) DumpHeap(workerId int64) (string, error) {
+	var fName string
+	err := this.withWorker(workerId, func(w *Worker) {
+		fName = this.writeHeapSnapshot(w)
+	})
+	return fName, err
+}
+
+// FullGC runs a full gc on the worker once it is idle.
+func (this *VmMgr) FullGC(workerId int64) error {
+	return this.withWorker(workerId, func(w *Worker) {
+		w.fullGC()
+	})
+}
+
+// FullGCAll runs a full gc on every idle worker and returns their ids.
+func (this *VmMgr) FullGCAll() []int64 {
+	ids := make([]int64, 0)
+	for _, info := range this.Workers() {
+		err := this.withWorkerTimeout(info.Id, 0, func(w *Worker) {
+			w.fullGC()
+		})
+		if err == nil {
+			ids = append(ids, info.Id)
+		}
+	}
+	return ids
+}
+
+func (this *VmMgr) withWorker(workerId int64, fn func(w *Worker)) error {
+	return this.withWorkerTimeout(workerId, VmAcquireTimeout*time.Second, fn)
+}
+
+func (this *VmMgr) withWorkerTimeout(workerId int64, timeout time.Duration, fn func(w *Worker)) error {
+	this.mutex.Lock()
+	w := this.allWorkers[workerId]
+	this.mutex.Unlock()
+	if w == nil {
+		return ErrorVmNotFound
+	}
+
+	err := w.acquireIdle(timeout)
+	if err != nil {
+		return err
+	}
+	err = w.runLocked(func() { fn(w) })
+	w.Release()
+	return err
+}
+
+func (this *VmMgr) writeHeapSnapshot(w *Worker) string {
+	n := rand.Int31n(1000)
+	fName := time.Now().Format("20060102-150405") + fmt.Sprintf("-%03d.heapsnapshot", n)
+	fName = path.Join(this.DumpHeapDir, fName)
+	w.isolate.WriteSnapshot(fName, true)
+	tlog.Infof("worker %d heap snapshot: %s", w.Id, fName)
+	return fName
+}
@@ -0,0 +1,131 @@
+package v8_test
+
+import (
+	"context"
+	"errors"
+	v8 "github.com/lizc2003/vue-ssr-v8go/server/v8"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	writeServerJs := func(js string) {
+		if err := os.WriteFile(dir+"/server.js", []byte(js), 0644); err != nil {
+			t.Fatalf("write server.js err: %v", err)
+		}
+	}
+
+	results := make(chan string, 10)
+	callback := func(mtype int64, param1 int64, param2 string, param3 string, param4 string, param5 string) {
+		results <- param2
+	}
+	writeServerJs(`globalThis.v8goRenderToString = () => 'v1';`)
+	vmMgr, err := v8.NewVmMgr("prod", dir, callback, &v8.VmConfig{}, nil)
+	if err != nil {
+		t.Fatalf("create vm mgr err: %v", err)
+	}
+
+	render := func(expect string) {
+		_, err := vmMgr.Execute(0, `v8goGo.sendMessage(0, 0, v8goRenderToString(), '', '', '')`, "test.js")
+		if err != nil {
+			t.Fatalf("render err: %v", err)
+		}
+		select {
+		case r := <-results:
+			if r != expect {
+				t.Errorf("expect %s, got %s", expect, r)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("render timeout, expect %s", expect)
+		}
+	}
+
+	render("v1")
+
+	writeServerJs(`throw new Error('broken bundle');`)
+	if err = vmMgr.Reload(); err == nil {
+		t.Errorf("reload of a throwing bundle succeeded")
+	}
+	render("v1")
+
+	writeServerJs(`globalThis.renderToString = () => 'v2';`)
+	if err = vmMgr.Reload(); err == nil {
+		t.Errorf("reload of a bundle without v8goRenderToString succeeded")
+	}
+	render("v1")
+
+	writeServerJs(`globalThis.v8goRenderToString = () => 'v2';`)
+	if err = vmMgr.Reload(); err != nil {
+		t.Fatalf("reload err: %v", err)
+	}
+	render("v2")
+}
+
+func TestExecuteCanceled(t *testing.T) {
+	results := make(chan string, 10)
+	callback := func(mtype int64, param1 int64, param2 string, param3 string, param4 string, param5 string) {
+		results <- param2
+	}
+	vmMgr, err := v8.NewVmMgr("dev", "", callback, &v8.VmConfig{}, nil)
+	if err != nil {
+		t.Fatalf("create vm mgr err: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = vmMgr.ExecuteWithStat(ctx, 1, `v8goGo.sendMessage(0, 0, 'executed', '', '', '')`, "test.js")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expect canceled, got %v", err)
+	}
+	select {
+	case r := <-results:
+		t.Errorf("canceled render executed: %s", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestJsTime(t *testing.T) {
+	var jsTime time.Duration
+	done := make(chan time.Duration, 1)
+	callback := func(mtype int64, param1 int64, param2 string, param3 string, param4 string, param5 string) {
+		switch mtype {
+		case 16:
+			elapse, _ := strconv.ParseInt(param2, 10, 64)
+			jsTime += time.Duration(elapse)
+		case 10:
+			done <- jsTime
+		}
+	}
+	vmMgr, err := v8.NewVmMgr("dev", "", callback, &v8.VmConfig{}, nil)
+	if err != nil {
+		t.Fatalf("create vm mgr err: %v", err)
+	}
+
+	code := `
+(function() {
+	const busy = (ms) => { const end = Date.now() + ms; while (Date.now() < end) {} };
+	busy(50);
+	setTimeout(() => {
+		busy(50);
+		v8goGo.sendMessage(10, 3, '', '', '', '');
+	}, 300);
+})();
+`
+	_, err = vmMgr.Execute(3, code, "test.js")
+	if err != nil {
+		t.Fatalf("test fail: %v", err)
+	}
+	select {
+	case d := <-done:
+		// the 300ms wait for the timer is not js time
+		if d < 90*time.Millisecond || d > 250*time.Millisecond {
+			t.Errorf("unexpected js time: %v", d)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("js time test timeout")
+	}
+	vmMgr.CloseRender(3)
+}
@@ -0,0 +1,175 @@
+package v8
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var ErrorXhrDenied = errors.New("denied by outbound policy")
+
+// OutboundConfig restricts the urls requested by xhr and fetch during
+// render. An empty list allows everything. Hosts are exact names or
+// "*.example.com" for the subdomains; the origin host is always allowed.
+type OutboundConfig struct {
+	AllowHosts   []string `toml:"allow_hosts"`
+	AllowSchemes []string `toml:"allow_schemes"`
+	AllowPorts   []int    `toml:"allow_ports"`
+	DenyCidrs    []string `toml:"deny_cidrs"`
+}
+
+type OutboundPolicy struct {
+	hosts    []string
+	suffixes []string
+	schemes  map[string]bool
+	ports    map[int]bool
+	denied   []netip.Prefix
+}
+
+type outboundExemptKey struct{}
+type outboundExemptHostKey struct{}
+
+func NewOutboundPolicy(c *OutboundConfig) (*OutboundPolicy, error) {
+	if len(c.AllowHosts) == 0 && len(c.AllowSchemes) == 0 && len(c.AllowPorts) == 0 && len(c.DenyCidrs) == 0 {
+		return nil, nil
+	}
+
+	this := &OutboundPolicy{}
+	for _, h := range c.AllowHosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if suffix, ok := strings.CutPrefix(h, "*."); ok {
+			this.suffixes = append(this.suffixes, "."+suffix)
+		} else if h != "" {
+			this.hosts = append(this.hosts, h)
+		}
+	}
+	if len(c.AllowSchemes) > 0 {
+		this.schemes = make(map[string]bool, len(c.AllowSchemes))
+		for _, s := range c.AllowSchemes {
+			this.schemes[strings.ToLower(strings.TrimSpace(s))] = true
+		}
+	}
+	if len(c.AllowPorts) > 0 {
+		this.ports = make(map[int]bool, len(c.AllowPorts))
+		for _, p := range c.AllowPorts {
+			this.ports[p] = true
+		}
+	}
+	for _, cidr := range c.DenyCidrs {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid outbound deny cidr: %s", cidr)
+		}
+		this.denied = append(this.denied, prefix.Masked())
+	}
+	return this, nil
+}
+
+// Check checks the scheme, host and port of u.
+func (this *OutboundPolicy) Check(u *url.URL) error {
+	scheme := strings.ToLower(u.Scheme)
+	if this.schemes != nil && !this.schemes[scheme] {
+		return fmt.Errorf("%w: scheme %s", ErrorXhrDenied, scheme)
+	}
+
+	if this.ports != nil {
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if scheme == "https" {
+				port = "443"
+			}
+		}
+		n, _ := strconv.Atoi(port)
+		if !this.ports[n] {
+			return fmt.Errorf("%w: port %s", ErrorXhrDenied, port)
+		}
+	}
+
+	if len(this.hosts) > 0 || len(this.suffixes) > 0 {
+		host := strings.ToLower(u.Hostname())
+		if !this.isHostAllowed(host) {
+			return fmt.Errorf("%w: host %s", ErrorXhrDenied, host)
+		}
+	}
+	return nil
+}
+
+func (this *OutboundPolicy) isHostAllowed(host string) bool {
+	for _, h := range this.hosts {
+		if h == host {
+			return true
+		}
+	}
+	for _, suffix := range this.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDial is the dialer control, called with the resolved address of
+// every new connection, so that a name can't be rebound to a denied one.
+func (this *OutboundPolicy) checkDial(ctx context.Context, network, address string, _ syscall.RawConn) error {
+	if exempt, _ := ctx.Value(outboundExemptKey{}).(bool); exempt {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: address %s", ErrorXhrDenied, address)
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range this.denied {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("%w: address %s", ErrorXhrDenied, addr)
+		}
+	}
+	return nil
+}
+
+// withOutboundExempt exempts the requests to the target of a rewrite rule,
+// whose address is configured by the server, not built by the page. Only
+// u's host is exempt: a redirect or a dial elsewhere is still checked.
+func withOutboundExempt(ctx context.Context, u *url.URL) context.Context {
+	return context.WithValue(ctx, outboundExemptHostKey{}, hostPort(u))
+}
+
+func isOutboundExempt(ctx context.Context, hostport string) bool {
+	exemptHost, _ := ctx.Value(outboundExemptHostKey{}).(string)
+	return exemptHost != "" && strings.EqualFold(exemptHost, hostport)
+}
+
+// hostPort returns the host:port of u, with the default port of its scheme.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if strings.EqualFold(u.Scheme, "https") {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func dialControl(policy *OutboundPolicy) func(ctx context.Context, network, address string, c syscall.RawConn) error {
+	if policy == nil || len(policy.denied) == 0 {
+		return nil
+	}
+	return policy.checkDial
+}
+
+// dialContext marks the dials to the exempt host, so that checkDial, which
+// only sees the resolved address, lets them through.
+func dialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		ctx = context.WithValue(ctx, outboundExemptKey{}, isOutboundExempt(ctx, address))
+		return dialer.DialContext(ctx, network, address)
+	}
+}
@@ -0,0 +1,95 @@
+package v8_test
+
+import (
+	v8 "github.com/lizc2003/vue-ssr-v8go/server/v8"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutboundPolicy(t *testing.T) {
+	var tsUrl *url.URL
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/", http.StatusFound)
+		case "/localhost":
+			http.Redirect(w, r, "http://localhost:"+tsUrl.Port()+"/", http.StatusFound)
+		case "/other":
+			http.Redirect(w, r, "http://other.test/", http.StatusFound)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer ts.Close()
+	tsUrl, _ = url.Parse(ts.URL)
+
+	results := make(chan string, 10)
+	callback := func(mtype int64, param1 int64, param2 string, param3 string, param4 string, param5 string) {
+		if mtype == 0 {
+			results <- param2
+		}
+	}
+	vc := &v8.VmConfig{}
+	vc.Outbound.AllowHosts = []string{"localhost", "*.example.com"}
+	vc.Outbound.DenyCidrs = []string{"127.0.0.0/8", "::1/128"}
+	rules := []v8.RewriteRule{{Host: "origin.test", Target: tsUrl}}
+	vmMgr, err := v8.NewVmMgr("dev", "", callback, vc, rules)
+	if err != nil {
+		t.Fatalf("create vm mgr err: %v", err)
+	}
+
+	code := `
+(function() {
+	const report = (name, ok) => v8goGo.sendMessage(0, 0, name + (ok ? ':ok' : ':fail'), '', '', '');
+	const get = (name, url, expected) => {
+		let xhr = new XMLHttpRequest();
+		xhr.open('GET', url);
+		xhr.onload = () => report(name, expected === 'load' && xhr.responseText === 'ok');
+		xhr.onerror = () => report(name, expected === 'error');
+		xhr.send();
+	};
+
+	get('origin', 'http://origin.test/', 'load');
+	get('host', '` + ts.URL + `/', 'error');
+	get('cidr', 'http://localhost:` + tsUrl.Port() + `/', 'error');
+	get('redirect-same', 'http://origin.test/same', 'load');
+	get('redirect-cidr', 'http://origin.test/localhost', 'error');
+	get('redirect-host', 'http://origin.test/other', 'error');
+})();
+`
+	_, err = vmMgr.Execute(0, code, "test.js")
+	if err != nil {
+		t.Fatalf("test fail: %v", err)
+	}
+
+	for i := 0; i < 6; i++ {
+		select {
+		case r := <-results:
+			t.Log(r)
+			if !strings.HasSuffix(r, ":ok") {
+				t.Errorf("outbound policy test failed: %s", r)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("outbound policy test timeout")
+		}
+	}
+}
+
+func TestOutboundProxy(t *testing.T) {
+	vc := &v8.VmConfig{}
+	vc.HttpClient.Proxy = "http://127.0.0.1:3128"
+	vc.Outbound.DenyCidrs = []string{"169.254.0.0/16"}
+	if _, err := v8.NewVmMgr("dev", "", nil, vc, nil); err == nil {
+		t.Errorf("deny_cidrs with a proxy accepted")
+	}
+
+	vc.Outbound.DenyCidrs = nil
+	vc.Outbound.AllowHosts = []string{"*.example.com"}
+	if _, err := v8.NewVmMgr("dev", "", nil, vc, nil); err != nil {
+		t.Errorf("create vm mgr err: %v", err)
+	}
+}
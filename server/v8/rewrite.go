@@ -0,0 +1,67 @@
+package v8
+
+import (
+	"net/url"
+	"strings"
+)
+
+// RewriteRule routes the xhrs to Host, and under Path when it is set, to
+// Target. The matched path prefix is replaced by the path of Target, if
+// any. HostHeader is the Host sent to the target, empty for the original
+// host. ForwardCookies allows the Cookie and Authorization of SSR-Headers,
+// and the Set-Cookie of the responses.
+type RewriteRule struct {
+	Host           string
+	Path           string
+	Target         *url.URL
+	HostHeader     string
+	ForwardCookies bool
+}
+
+func (this *RewriteRule) match(u *url.URL) bool {
+	if !strings.EqualFold(u.Host, this.Host) {
+		return false
+	}
+	if this.Path == "" || u.Path == this.Path {
+		return true
+	}
+	if !strings.HasPrefix(u.Path, this.Path) {
+		return false
+	}
+	return strings.HasSuffix(this.Path, "/") || u.Path[len(this.Path)] == '/'
+}
+
+// apply rewrites u to the target, it returns the Host header to send.
+func (this *RewriteRule) apply(u *url.URL) string {
+	host := u.Host
+	if this.HostHeader != "" {
+		host = this.HostHeader
+	}
+	if this.Target == nil {
+		return host
+	}
+
+	u.Scheme = this.Target.Scheme
+	u.Host = this.Target.Host
+	if prefix := strings.TrimSuffix(this.Target.EscapedPath(), "/"); prefix != "" {
+		rest := strings.TrimPrefix(u.EscapedPath(), strings.TrimSuffix(this.Path, "/"))
+		if rest != "" && rest[0] != '/' {
+			rest = "/" + rest
+		}
+		escaped := prefix + rest
+		if p, err := url.PathUnescape(escaped); err == nil {
+			u.Path = p
+			u.RawPath = escaped
+		}
+	}
+	return host
+}
+
+func matchRewriteRule(rules []RewriteRule, u *url.URL) *RewriteRule {
+	for i := range rules {
+		if rules[i].match(u) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package v8_test
+
+import (
+	"encoding/json"
+	v8 "github.com/lizc2003/vue-ssr-v8go/server/v8"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRewriteRules(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"path":   r.URL.Path,
+			"host":   r.Host,
+			"cookie": r.Header.Get("Cookie"),
+		})
+	}))
+	defer ts.Close()
+	tsUrl, _ := url.Parse(ts.URL)
+	innerUrl, _ := url.Parse(ts.URL + "/inner")
+
+	results := make(chan string, 10)
+	callback := func(mtype int64, param1 int64, param2 string, param3 string, param4 string, param5 string) {
+		if mtype == 0 {
+			results <- param2
+		}
+	}
+	rules := []v8.RewriteRule{
+		{Host: "api.test", Path: "/search/", Target: innerUrl},
+		{Host: "api.test", Target: tsUrl, HostHeader: "custom.test", ForwardCookies: true},
+	}
+	vmMgr, err := v8.NewVmMgr("dev", "", callback, &v8.VmConfig{}, rules)
+	if err != nil {
+		t.Fatalf("create vm mgr err: %v", err)
+	}
+
+	code := `
+(function() {
+	const report = (name, ok) => v8goGo.sendMessage(0, 0, name + (ok ? ':ok' : ':fail'), '', '', '');
+	const headers = {'SSR-Headers': JSON.stringify({Cookie: 'a=1'})};
+	fetch('http://api.test/search/q', {headers: headers}).then((resp) => resp.json()).then((r) => {
+		report('path', r.path === '/inner/q' && r.host === 'api.test' && r.cookie === '');
+	}).catch((e) => report('path ' + e, false));
+	fetch('http://api.test/user', {headers: headers}).then((resp) => resp.json()).then((r) => {
+		report('host', r.path === '/user' && r.host === 'custom.test' && r.cookie === 'a=1');
+	}).catch((e) => report('host ' + e, false));
+})();
+`
+	_, err = vmMgr.Execute(0, code, "test.js")
+	if err != nil {
+		t.Fatalf("test fail: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-results:
+			t.Log(r)
+			if !strings.HasSuffix(r, ":ok") {
+				t.Errorf("rewrite test failed: %s", r)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("rewrite test timeout")
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package v8
+
+import (
+	"encoding/json"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MinTimerDelay = 1 // milliseconds
+)
+
+type timerEvent struct {
+	Event    string  `json:"event"`
+	TimerId  int64   `json:"timer_id,omitempty"`
+	TimerIds []int64 `json:"timer_ids,omitempty"`
+	renderId int64
+}
+
+func (this *timerEvent) clone() workerEvent {
+	return this
+}
+
+func (this *timerEvent) getRenderId() int64 {
+	return this.renderId
+}
+
+func (this *timerEvent) dispatch(w *Worker) error {
+	w.beginJs(this.renderId)
+	err := doSendTimerEvent(w, this)
+	w.endJs()
+	if this.Event == "ontimer" {
+		ThisVmMgr.timerMgr.rearm(this.TimerId)
+	}
+	return err
+}
+
+type jsTimer struct {
+	id       int64
+	renderId int64
+	worker   *Worker
+	delay    time.Duration
+	repeat   bool
+	timer    *time.Timer
+}
+
+type TimerMgr struct {
+	mutex   sync.Mutex
+	timers  map[int64]*jsTimer
+	renders map[int64]map[int64]*jsTimer
+	workers map[*Worker]map[int64]*jsTimer
+	maxId   int64
+}
+
+func NewTimerMgr() *TimerMgr {
+	return &TimerMgr{
+		timers:  make(map[int64]*jsTimer),
+		renders: make(map[int64]map[int64]*jsTimer),
+		workers: make(map[*Worker]map[int64]*jsTimer),
+	}
+}
+
+func (this *TimerMgr) Add(w *Worker, delay int64, repeat bool) int64 {
+	if delay < MinTimerDelay {
+		delay = MinTimerDelay
+	}
+
+	t := &jsTimer{
+		renderId: w.renderId,
+		worker:   w,
+		delay:    time.Duration(delay) * time.Millisecond,
+		repeat:   repeat,
+	}
+
+	this.mutex.Lock()
+	this.maxId++
+	t.id = this.maxId
+	this.timers[t.id] = t
+	if t.renderId > 0 {
+		m, ok := this.renders[t.renderId]
+		if !ok {
+			m = make(map[int64]*jsTimer)
+			this.renders[t.renderId] = m
+		}
+		m[t.id] = t
+	}
+	m, ok := this.workers[w]
+	if !ok {
+		m = make(map[int64]*jsTimer)
+		this.workers[w] = m
+	}
+	m[t.id] = t
+	t.timer = time.AfterFunc(t.delay, func() { this.fire(t) })
+	this.mutex.Unlock()
+	return t.id
+}
+
+func (this *TimerMgr) Clear(timerId int64) {
+	this.mutex.Lock()
+	if t, ok := this.timers[timerId]; ok {
+		t.timer.Stop()
+		this.remove(t)
+	}
+	this.mutex.Unlock()
+}
+
+// CloseRender stops every timer created on behalf of the render and tells
+// the workers to drop the corresponding callbacks.
+func (this *TimerMgr) CloseRender(renderId int64) {
+	this.mutex.Lock()
+	m, ok := this.renders[renderId]
+	if !ok {
+		this.mutex.Unlock()
+		return
+	}
+
+	cleared := make(map[*Worker][]int64)
+	for _, t := range m {
+		t.timer.Stop()
+		this.remove(t)
+		cleared[t.worker] = append(cleared[t.worker], t.id)
+	}
+	this.mutex.Unlock()
+
+	for w, ids := range cleared {
+		tlog.Debugf("render %d clear %d timers", renderId, len(ids))
+		// not tagged with the render, so that dropping its events keeps it
+		w.sendEvent(&timerEvent{Event: "onclear", TimerIds: ids})
+	}
+}
+
+// CloseWorker stops the timers of a disposed worker, including those created
+// outside of a render, like an interval set when server.js is loaded.
+func (this *TimerMgr) CloseWorker(w *Worker) {
+	this.mutex.Lock()
+	for _, t := range this.workers[w] {
+		t.timer.Stop()
+		this.remove(t)
+	}
+	this.mutex.Unlock()
+}
+
+func (this *TimerMgr) fire(t *jsTimer) {
+	this.mutex.Lock()
+	if _, ok := this.timers[t.id]; !ok {
+		this.mutex.Unlock()
+		return
+	}
+	if !t.repeat {
+		this.remove(t)
+	}
+	this.mutex.Unlock()
+
+	t.worker.sendEvent(&timerEvent{Event: "ontimer", TimerId: t.id, renderId: t.renderId})
+}
+
+// rearm schedules the next tick of an interval only after the previous one
+// has been delivered, so a busy worker never accumulates queued ticks.
+func (this *TimerMgr) rearm(timerId int64) {
+	this.mutex.Lock()
+	if t, ok := this.timers[timerId]; ok && t.repeat {
+		t.timer.Reset(t.delay)
+	}
+	this.mutex.Unlock()
+}
+
+func (this *TimerMgr) remove(t *jsTimer) {
+	delete(this.timers, t.id)
+	if t.renderId > 0 {
+		if m, ok := this.renders[t.renderId]; ok {
+			delete(m, t.id)
+			if len(m) == 0 {
+				delete(this.renders, t.renderId)
+			}
+		}
+	}
+	if m, ok := this.workers[t.worker]; ok {
+		delete(m, t.id)
+		if len(m) == 0 {
+			delete(this.workers, t.worker)
+		}
+	}
+}
+
+func doSendTimerEvent(w *Worker, evt *timerEvent) error {
+	s, err := json.Marshal(evt)
+	if err != nil {
+		tlog.Error(err)
+		return err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("v8goJs.timerMgr.sendEvent(")
+	sb.Write(s)
+	sb.WriteByte(')')
+
+	_, err = w.v8ctx.RunScript(sb.String(), "send_timer_event.js")
+	if err != nil {
+		err = ToJsError(err)
+		tlog.Errorf("timer %d-%d send %s, error: %v", evt.renderId, evt.TimerId, evt.Event, err)
+	}
+	return err
+}
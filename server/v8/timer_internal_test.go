@@ -0,0 +1,35 @@
+package v8
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimerCloseWorker(t *testing.T) {
+	callback := func(mtype int64, param1 int64, param2 string, param3 string, param4 string, param5 string) {}
+	vmMgr, err := NewVmMgr("dev", "", callback, &VmConfig{InstanceLifetime: 60}, nil)
+	if err != nil {
+		t.Fatalf("create vm mgr err: %v", err)
+	}
+
+	// an interval outside of any render is never cleared by CloseRender
+	workerId, err := vmMgr.Execute(0, `setInterval(() => {}, 10)`, "test.js")
+	if err != nil {
+		t.Fatalf("test fail: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	vmMgr.Recycle()
+	time.Sleep(vmMgr.vmDeleteDelayTime + 200*time.Millisecond)
+
+	vmMgr.timerMgr.mutex.Lock()
+	defer vmMgr.timerMgr.mutex.Unlock()
+	for _, timer := range vmMgr.timerMgr.timers {
+		if timer.worker.Id == workerId {
+			t.Errorf("timer %d of retired worker %d is still armed", timer.id, workerId)
+		}
+	}
+	if len(vmMgr.timerMgr.workers) > 0 {
+		t.Errorf("timers of %d workers left", len(vmMgr.timerMgr.workers))
+	}
+}
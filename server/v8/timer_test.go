@@ -0,0 +1,69 @@
+package v8_test
+
+import (
+	v8 "github.com/lizc2003/vue-ssr-v8go/server/v8"
+	"testing"
+	"time"
+)
+
+func TestTimer(t *testing.T) {
+	results := make(chan string, 10)
+	callback := func(mtype int64, param1 int64, param2 string, param3 string, param4 string, param5 string) {
+		if mtype == 0 {
+			results <- param2
+		}
+	}
+	vmMgr, err := v8.NewVmMgr("dev", "", callback, &v8.VmConfig{}, nil)
+	if err != nil {
+		t.Fatalf("create vm mgr err: %v", err)
+	}
+
+	_, err = vmMgr.Execute(0, testTimerJsContent, "test.js")
+	if err != nil {
+		t.Fatalf("test fail: %v", err)
+	}
+	_, err = vmMgr.Execute(7, `setTimeout(() => v8goGo.sendMessage(0, 0, 'render-timer', '', '', ''), 100)`, "test2.js")
+	if err != nil {
+		t.Fatalf("test fail: %v", err)
+	}
+	vmMgr.CloseRender(7)
+
+	expects := []string{"microtask", "timeout-10", "timeout-50:a,b", "interval:3"}
+	for _, expect := range expects {
+		select {
+		case r := <-results:
+			if r != expect {
+				t.Errorf("expect %s, got %s", expect, r)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timer test timeout, expect %s", expect)
+		}
+	}
+
+	select {
+	case r := <-results:
+		t.Errorf("unexpected message: %s", r)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+const testTimerJsContent = `
+(function() {
+	const report = (msg) => v8goGo.sendMessage(0, 0, msg, '', '', '');
+
+	setTimeout((a, b) => report('timeout-50:' + a + ',' + b), 50, 'a', 'b');
+	setTimeout(() => report('timeout-10'), 10);
+	let cleared = setTimeout(() => report('cleared'), 20);
+	clearTimeout(cleared);
+	queueMicrotask(() => report('microtask'));
+
+	let count = 0;
+	let interval = setInterval(() => {
+		count++;
+		if (count === 3) {
+			clearInterval(interval);
+			setTimeout(() => report('interval:' + count), 100);
+		}
+	}, 60);
+})();
+`
@@ -4,28 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/lizc2003/v8go"
-	"net"
-	"net/http"
 	"net/url"
-	"time"
 )
 
-func newHttpClient() *http.Client {
-	return &http.Client{
-		Timeout: 8 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:          200,
-			MaxIdleConnsPerHost:   100,
-			IdleConnTimeout:       15 * time.Second,
-			DisableCompression:    true,
-			ResponseHeaderTimeout: 6 * time.Second,
-			DialContext: (&net.Dialer{
-				Timeout: 2 * time.Second,
-			}).DialContext,
-		},
-	}
-}
-
 func ToJsError(err error) error {
 	var jsErr *v8go.JSError
 	if errors.As(err, &jsErr) {
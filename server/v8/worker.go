@@ -7,8 +7,10 @@ import (
 	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/util"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,6 +21,7 @@ type xhrEvent struct {
 	Status   int32             `json:"status,omitempty"`
 	Headers  map[string]string `json:"headers,omitempty"`
 	Response string            `json:"response,omitempty"`
+	Encoding string            `json:"encoding,omitempty"`
 	renderId int64
 }
 
@@ -28,6 +31,7 @@ func (this *xhrEvent) Reset() {
 	this.Status = 0
 	this.Headers = nil
 	this.Response = ""
+	this.Encoding = ""
 }
 
 func (this *xhrEvent) Clone() *xhrEvent {
@@ -35,6 +39,29 @@ func (this *xhrEvent) Clone() *xhrEvent {
 	return &evt
 }
 
+func (this *xhrEvent) clone() workerEvent {
+	return this.Clone()
+}
+
+func (this *xhrEvent) getRenderId() int64 {
+	return this.renderId
+}
+
+func (this *xhrEvent) dispatch(w *Worker) error {
+	w.beginJs(this.renderId)
+	err := doSendXhrEvent(w, this)
+	w.endJs()
+	return err
+}
+
+// workerEvent is an asynchronous event delivered into the worker's context.
+// Events arriving while the worker is running are queued and delivered on Release.
+type workerEvent interface {
+	clone() workerEvent
+	dispatch(w *Worker) error
+	getRenderId() int64
+}
+
 type SendMessageCallback func(mtype int64, param1 int64, param2 string, param3 string, param4 string, param5 string)
 
 type Worker struct {
@@ -47,15 +74,41 @@ type Worker struct {
 	disposed   bool
 	running    bool
 	mutex      sync.Mutex
-	evtQueue   []*xhrEvent
+	evtQueue   []workerEvent
 	callback   SendMessageCallback
 	expireTime int64
+	renderId   int64
+	jsBegin    time.Time
+	lockedFn   func()
+	generation int64
+	replaced   int32
+
+	createTime  int64
+	renderCount int64
+	heapUsed    uint64
+	heapTotal   uint64
 
 	lastUsedHeap  uint64
 	checkHeapTime int64
 }
 
+type WorkerInfo struct {
+	Id          int64  `json:"id"`
+	Generation  int64  `json:"generation"`
+	CreateTime  int64  `json:"create_time"`
+	Age         int64  `json:"age"`
+	ExpireTime  int64  `json:"expire_time"`
+	Busy        bool   `json:"busy"`
+	HeapUsed    uint64 `json:"heap_used"`
+	HeapTotal   uint64 `json:"heap_total"`
+	RenderCount int64  `json:"render_count"`
+}
+
 func NewWorker(callback SendMessageCallback, workerId int64) (*Worker, error) {
+	return newWorker(callback, workerId, gServerScript.Load())
+}
+
+func newWorker(callback SendMessageCallback, workerId int64, ss *serverScript) (*Worker, error) {
 	isolate := v8go.NewIsolate()
 	client := v8go.NewInspectorClient(newConsoleObj())
 	inspector := v8go.NewInspector(isolate, client)
@@ -69,6 +122,7 @@ func NewWorker(callback SendMessageCallback, workerId int64) (*Worker, error) {
 		inspector:       inspector,
 		v8ctx:           v8ctx,
 		callback:        callback,
+		createTime:      time.Now().Unix(),
 	}
 
 	script, err := isolate.CompileUnboundScript(gInitJs, gInitJsName, v8go.CompileOptions{CachedData: gInitJsCache})
@@ -80,8 +134,8 @@ func NewWorker(callback SendMessageCallback, workerId int64) (*Worker, error) {
 		goto ERROR
 	}
 
-	if gServerJsCache != nil {
-		script, err = isolate.CompileUnboundScript(gServerJs, gServerJsName, v8go.CompileOptions{CachedData: gServerJsCache})
+	if ss != nil {
+		script, err = isolate.CompileUnboundScript(ss.js, gServerJsName, v8go.CompileOptions{CachedData: ss.cache})
 		if err != nil {
 			goto ERROR
 		}
@@ -121,6 +175,13 @@ func (this *Worker) Dispose() {
 		return
 	}
 	this.disposed = true
+	if ThisVmMgr != nil {
+		ThisVmMgr.timerMgr.CloseWorker(this)
+	}
+
+	workerId := strconv.FormatInt(this.Id, 10)
+	metricWorkerHeapUsed.Delete(workerId)
+	metricWorkerHeapTotal.Delete(workerId)
 
 	this.inspector.ContextDestroyed(this.v8ctx)
 	this.v8ctx.Close()
@@ -143,11 +204,61 @@ func (this *Worker) Acquire() bool {
 	return bOK
 }
 
+// acquireIdle waits up to timeout for the worker to become idle and
+// acquires it.
+func (this *Worker) acquireIdle(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		this.mutex.Lock()
+		bDisposed := this.disposed
+		bOK := !bDisposed && !this.running
+		if bOK {
+			this.running = true
+		}
+		this.mutex.Unlock()
+
+		if bOK {
+			return nil
+		}
+		if bDisposed {
+			return ErrorVmNotFound
+		}
+		if time.Now().After(deadline) {
+			return ErrorVmBusy
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (this *Worker) isBusy() bool {
+	if this.mutex.TryLock() {
+		bBusy := this.running
+		this.mutex.Unlock()
+		return bBusy
+	}
+	return true
+}
+
+func (this *Worker) Info() WorkerInfo {
+	now := time.Now().Unix()
+	return WorkerInfo{
+		Id:          this.Id,
+		Generation:  this.generation,
+		CreateTime:  this.createTime,
+		Age:         now - this.createTime,
+		ExpireTime:  this.expireTime,
+		Busy:        this.isBusy(),
+		HeapUsed:    atomic.LoadUint64(&this.heapUsed),
+		HeapTotal:   atomic.LoadUint64(&this.heapTotal),
+		RenderCount: atomic.LoadInt64(&this.renderCount),
+	}
+}
+
 func (this *Worker) Release() {
 	this.mutex.Lock()
 	if len(this.evtQueue) > 0 {
 		for _, evt := range this.evtQueue {
-			doSendXhrEvent(this, evt)
+			evt.dispatch(this)
 		}
 		this.evtQueue = nil
 	}
@@ -155,43 +266,115 @@ func (this *Worker) Release() {
 	this.mutex.Unlock()
 }
 
-func (this *Worker) Execute(code string, scriptName string) error {
+func (this *Worker) Execute(renderId int64, code string, scriptName string) error {
+	this.beginJs(renderId)
+	if renderId > 0 {
+		atomic.AddInt64(&this.renderCount, 1)
+	}
 	_, err := this.v8ctx.RunScript(code, scriptName)
+	this.endJs()
 	if err != nil {
 		return ToJsError(err)
 	}
 	return nil
 }
 
+// beginJs and endJs surround the js run on behalf of a render, the script
+// and the events, to report its execution time.
+func (this *Worker) beginJs(renderId int64) {
+	this.renderId = renderId
+	this.jsBegin = time.Now()
+}
+
+func (this *Worker) endJs() {
+	this.reportJsTime()
+	this.renderId = 0
+}
+
+func (this *Worker) reportJsTime() {
+	if this.renderId > 0 && this.callback != nil {
+		now := time.Now()
+		this.callback(16, this.renderId, strconv.FormatInt(int64(now.Sub(this.jsBegin)), 10), "", "", "")
+		this.jsBegin = now
+	}
+}
+
 func (this *Worker) SendXhrEvent(evt *xhrEvent) error {
+	return this.sendEvent(evt)
+}
+
+func (this *Worker) sendEvent(evt workerEvent) error {
 	var err error
 	this.mutex.Lock()
 	if !this.disposed {
 		if this.running || len(this.evtQueue) > 0 {
-			this.evtQueue = append(this.evtQueue, evt.Clone())
+			this.evtQueue = append(this.evtQueue, evt.clone())
 		} else {
-			err = doSendXhrEvent(this, evt)
+			err = evt.dispatch(this)
 		}
 	}
 	this.mutex.Unlock()
 	return err
 }
 
+// dropRenderEvents discards the queued events of a closed render.
+func (this *Worker) dropRenderEvents(renderId int64) {
+	this.mutex.Lock()
+	if len(this.evtQueue) > 0 {
+		queue := this.evtQueue[:0]
+		for _, evt := range this.evtQueue {
+			if evt.getRenderId() != renderId {
+				queue = append(queue, evt)
+			}
+		}
+		if n := len(this.evtQueue) - len(queue); n > 0 {
+			clear(this.evtQueue[len(queue):])
+			tlog.Debugf("render %d drop %d queued events", renderId, n)
+		}
+		this.evtQueue = queue
+	}
+	this.mutex.Unlock()
+}
+
 func (this *Worker) CheckHeap() bool {
 	if time.Now().Unix() > this.checkHeapTime {
 		this.checkHeapTime = time.Now().Unix() + CheckHeapInterval
-		heapSize := this.isolate.GetHeapStatistics().UsedHeapSize
+		heapStat := this.isolate.GetHeapStatistics()
+		heapSize := heapStat.UsedHeapSize
+		atomic.StoreUint64(&this.heapUsed, heapSize)
+		atomic.StoreUint64(&this.heapTotal, heapStat.TotalHeapSize)
+		workerId := strconv.FormatInt(this.Id, 10)
+		metricWorkerHeapUsed.Set(float64(heapSize), workerId)
+		metricWorkerHeapTotal.Set(float64(heapStat.TotalHeapSize), workerId)
 		if heapSize > CheckHeapSize &&
 			heapSize > this.lastUsedHeap*CheckHeapGrowRatio/100 {
 			this.lastUsedHeap = heapSize
-			this.isolate.FullGC()
-			tlog.Infof("worker %d trigger gc, used heap size: %dM", this.Id, heapSize/1024/1024)
+			this.fullGC()
 			return true
 		}
 	}
 	return false
 }
 
+// runLocked calls fn back from the js, where the isolate is locked and
+// entered on the current thread, as FullGC and WriteSnapshot expect.
+func (this *Worker) runLocked(fn func()) error {
+	this.lockedFn = fn
+	_, err := this.v8ctx.RunScript("v8goGo.runLocked()", "admin.js")
+	this.lockedFn = nil
+	if err != nil {
+		return ToJsError(err)
+	}
+	return nil
+}
+
+func (this *Worker) fullGC() {
+	heapSize := this.isolate.GetHeapStatistics().UsedHeapSize
+	this.isolate.FullGC()
+	metricWorkerGc.Inc()
+	tlog.Infof("worker %d trigger gc, used heap size: %dM", this.Id, heapSize/1024/1024)
+}
+
 func (this *Worker) SetExpireTime(expireTime int64) {
 	this.expireTime = expireTime
 }
@@ -218,11 +401,38 @@ func setFunctionCallback(w *Worker) error {
 	})
 	v8goOT.Set("handleXhrCmd", xhrCmd)
 
+	setTimer := v8go.NewFunctionTemplate(w.isolate, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		var ret *v8go.Value
+		args := info.Args()
+		if len(args) >= 2 {
+			timerId := ThisVmMgr.timerMgr.Add(w, args[0].Integer(), args[1].Boolean())
+			ret, _ = v8go.NewValue(w.isolate, strconv.FormatInt(timerId, 10))
+		}
+		info.Release()
+		return ret
+	})
+	v8goOT.Set("setTimer", setTimer)
+
+	clearTimer := v8go.NewFunctionTemplate(w.isolate, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		args := info.Args()
+		if len(args) >= 1 {
+			ThisVmMgr.timerMgr.Clear(args[0].Integer())
+		}
+		info.Release()
+		return nil
+	})
+	v8goOT.Set("clearTimer", clearTimer)
+
 	sendMessage := v8go.NewFunctionTemplate(w.isolate, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
 		if w.callback != nil {
 			args := info.Args()
 			if len(args) >= 6 {
-				w.callback(args[0].Integer(), args[1].Integer(),
+				mtype := args[0].Integer()
+				if (mtype == 10 || mtype == 11) && args[1].Integer() == w.renderId {
+					// the render is closed by its result, report the time before
+					w.reportJsTime()
+				}
+				w.callback(mtype, args[1].Integer(),
 					args[2].String(),
 					args[3].String(),
 					args[4].String(),
@@ -235,6 +445,15 @@ func setFunctionCallback(w *Worker) error {
 	})
 	v8goOT.Set("sendMessage", sendMessage)
 
+	runLocked := v8go.NewFunctionTemplate(w.isolate, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		if w.lockedFn != nil {
+			w.lockedFn()
+		}
+		info.Release()
+		return nil
+	})
+	v8goOT.Set("runLocked", runLocked)
+
 	v8goObj, err := v8goOT.NewInstance(w.v8ctx)
 	if err != nil {
 		return err
@@ -0,0 +1,335 @@
+package v8
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	XhrCacheMiss        = "MISS"
+	XhrCacheHit         = "HIT"
+	XhrCacheCoalesced   = "COALESCED"
+	XhrCacheRevalidated = "REVALIDATED"
+
+	DefaultXhrCacheBytes = 32 * 1024 * 1024
+)
+
+var DefaultXhrCacheKeyHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language", "Authorization", "Cookie"}
+
+// XhrCacheRule sets the ttl of the GET responses whose url starts with Url,
+// overriding the Cache-Control of the backend. A ttl of 0 disables caching.
+type XhrCacheRule struct {
+	Url string `toml:"url"`
+	Ttl int    `toml:"ttl"`
+}
+
+type XhrCacheConfig struct {
+	Enable     bool           `toml:"enable"`
+	MaxBytes   int64          `toml:"max_bytes"`
+	KeyHeaders []string       `toml:"key_headers"`
+	Rules      []XhrCacheRule `toml:"rule"`
+}
+
+type xhrResult struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+type xhrCacheEntry struct {
+	key       string
+	result    *xhrResult
+	freshTime time.Time
+	elem      *list.Element
+}
+
+type xhrFlight struct {
+	done   chan struct{}
+	result *xhrResult
+	err    error
+	shared bool
+}
+
+// XhrCache caches the GET responses of xhrs across renders, and coalesces
+// identical requests in flight, so that one request serves all of them.
+type XhrCache struct {
+	mutex      sync.Mutex
+	rules      []XhrCacheRule
+	keyHeaders []string
+	entries    map[string]*xhrCacheEntry
+	flights    map[string]*xhrFlight
+	lru        *list.List
+	curBytes   int64
+	maxBytes   int64
+}
+
+func NewXhrCache(c *XhrCacheConfig) *XhrCache {
+	if !c.Enable {
+		return nil
+	}
+
+	rules := make([]XhrCacheRule, 0, len(c.Rules))
+	for _, rule := range c.Rules {
+		if rule.Url == "" {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].Url) > len(rules[j].Url)
+	})
+
+	keyHeaders := DefaultXhrCacheKeyHeaders
+	if len(c.KeyHeaders) > 0 {
+		keyHeaders = make([]string, 0, len(c.KeyHeaders))
+		for _, h := range c.KeyHeaders {
+			keyHeaders = append(keyHeaders, http.CanonicalHeaderKey(strings.TrimSpace(h)))
+		}
+	}
+
+	maxBytes := c.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultXhrCacheBytes
+	}
+
+	return &XhrCache{
+		rules:      rules,
+		keyHeaders: keyHeaders,
+		entries:    make(map[string]*xhrCacheEntry),
+		flights:    make(map[string]*xhrFlight),
+		lru:        list.New(),
+		maxBytes:   maxBytes,
+	}
+}
+
+// Key returns the cache key of request, or "" when it must not be cached.
+func (this *XhrCache) Key(request *http.Request) string {
+	if request.Method != http.MethodGet || request.Body != nil {
+		return ""
+	}
+	header := request.Header
+	if header.Get("If-None-Match") != "" || header.Get("If-Modified-Since") != "" || header.Get("Range") != "" {
+		return ""
+	}
+	if cc := strings.ToLower(header.Get("Cache-Control")); strings.Contains(cc, "no-cache") || strings.Contains(cc, "no-store") {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString(request.Host)
+	sb.WriteByte(' ')
+	sb.WriteString(request.URL.String())
+	for _, h := range this.keyHeaders {
+		sb.WriteByte('\n')
+		sb.WriteString(header.Get(h))
+	}
+	return sb.String()
+}
+
+// Do returns the response of key from the cache or from an identical
+// request in flight, and otherwise calls fetch. When an expired entry has
+// a validator, it is passed to fetch for a conditional request.
+func (this *XhrCache) Do(ctx context.Context, key string, url string,
+	fetch func(stale *xhrResult) (*xhrResult, error)) (*xhrResult, string, error) {
+	now := time.Now()
+
+	this.mutex.Lock()
+	var stale *xhrResult
+	if entry, ok := this.entries[key]; ok {
+		if now.Before(entry.freshTime) {
+			this.lru.MoveToFront(entry.elem)
+			this.mutex.Unlock()
+			return entry.result, XhrCacheHit, nil
+		}
+		if hasValidator(entry.result.header) {
+			stale = entry.result
+		} else {
+			this.remove(entry)
+		}
+	}
+	if flight, ok := this.flights[key]; ok {
+		this.mutex.Unlock()
+		select {
+		case <-flight.done:
+		case <-ctx.Done():
+			return nil, XhrCacheCoalesced, ctx.Err()
+		}
+		if flight.shared {
+			return flight.result, XhrCacheCoalesced, nil
+		}
+		// the response was an error or private to the first request
+		result, err := fetch(nil)
+		return result, XhrCacheMiss, err
+	}
+	flight := &xhrFlight{done: make(chan struct{})}
+	this.flights[key] = flight
+	this.mutex.Unlock()
+
+	state := XhrCacheMiss
+	result, err := fetch(stale)
+	if err == nil && stale != nil && result.status == http.StatusNotModified {
+		state = XhrCacheRevalidated
+		header := stale.header.Clone()
+		for k, v := range result.header {
+			header[k] = v
+		}
+		result = &xhrResult{status: stale.status, header: header, body: stale.body}
+	}
+
+	shared := err == nil && this.isShareable(result)
+	var ttl time.Duration
+	if shared && result.status == http.StatusOK {
+		ttl = this.ttl(url, result.header)
+	}
+
+	this.mutex.Lock()
+	delete(this.flights, key)
+	if entry, ok := this.entries[key]; ok && (ttl > 0 || stale != nil) {
+		this.remove(entry)
+	}
+	if ttl > 0 {
+		this.set(key, result, now.Add(ttl))
+	}
+	this.mutex.Unlock()
+
+	flight.result = result
+	flight.err = err
+	flight.shared = shared
+	close(flight.done)
+	return result, state, err
+}
+
+// isShareable reports whether a response may be handed to other renders.
+func (this *XhrCache) isShareable(result *xhrResult) bool {
+	if len(result.header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	if strings.Contains(strings.ToLower(result.header.Get("Cache-Control")), "private") {
+		return false
+	}
+	for _, v := range result.header.Values("Vary") {
+		for _, h := range strings.Split(v, ",") {
+			h = http.CanonicalHeaderKey(strings.TrimSpace(h))
+			if h == "*" || !this.isKeyHeader(h) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (this *XhrCache) isKeyHeader(h string) bool {
+	for _, k := range this.keyHeaders {
+		if k == h {
+			return true
+		}
+	}
+	return false
+}
+
+// ttl returns the ttl of the matching rule, or the freshness lifetime given
+// by the backend.
+func (this *XhrCache) ttl(url string, header http.Header) time.Duration {
+	for i := range this.rules {
+		if strings.HasPrefix(url, this.rules[i].Url) {
+			return time.Duration(this.rules[i].Ttl) * time.Second
+		}
+	}
+	return freshnessLifetime(header)
+}
+
+func freshnessLifetime(header http.Header) time.Duration {
+	maxAge := -1
+	for _, item := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(item), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache", "private":
+			return 0
+		case "s-maxage":
+			if n, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+				maxAge = n
+			}
+		case "max-age":
+			if n, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil && maxAge < 0 {
+				maxAge = n
+			}
+		}
+	}
+
+	var lifetime time.Duration
+	if maxAge >= 0 {
+		lifetime = time.Duration(maxAge) * time.Second
+	} else if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		lifetime = t.Sub(date)
+	}
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil && age > 0 {
+		lifetime -= time.Duration(age) * time.Second
+	}
+	return lifetime
+}
+
+func hasValidator(header http.Header) bool {
+	return header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+}
+
+func (this *XhrCache) set(key string, result *xhrResult, freshTime time.Time) {
+	size := entrySize(key, result)
+	if size > this.maxBytes {
+		return
+	}
+	entry := &xhrCacheEntry{
+		key:       key,
+		result:    result,
+		freshTime: freshTime,
+	}
+	entry.elem = this.lru.PushFront(entry)
+	this.entries[key] = entry
+	this.curBytes += size
+
+	for this.curBytes > this.maxBytes {
+		back := this.lru.Back()
+		if back == nil {
+			break
+		}
+		this.remove(back.Value.(*xhrCacheEntry))
+	}
+}
+
+func (this *XhrCache) remove(entry *xhrCacheEntry) {
+	this.lru.Remove(entry.elem)
+	delete(this.entries, entry.key)
+	this.curBytes -= entrySize(entry.key, entry.result)
+}
+
+func entrySize(key string, result *xhrResult) int64 {
+	size := len(key) + len(result.body)
+	for k, v := range result.header {
+		size += len(k)
+		for _, s := range v {
+			size += len(s)
+		}
+	}
+	return int64(size)
+}
+
+func (this *XhrCache) Purge() {
+	this.mutex.Lock()
+	clear(this.entries)
+	this.lru.Init()
+	this.curBytes = 0
+	this.mutex.Unlock()
+}
@@ -0,0 +1,85 @@
+package v8_test
+
+import (
+	v8 "github.com/lizc2003/vue-ssr-v8go/server/v8"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestXhrCache(t *testing.T) {
+	var configHits, privateHits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config":
+			atomic.AddInt32(&configHits, 1)
+			time.Sleep(200 * time.Millisecond)
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte("config"))
+		case "/private":
+			atomic.AddInt32(&privateHits, 1)
+			time.Sleep(200 * time.Millisecond)
+			w.Header().Set("Set-Cookie", "sid=1")
+			w.Write([]byte("private"))
+		}
+	}))
+	defer ts.Close()
+
+	results := make(chan string, 20)
+	callback := func(mtype int64, param1 int64, param2 string, param3 string, param4 string, param5 string) {
+		if mtype == 0 {
+			results <- param2
+		}
+	}
+	vc := &v8.VmConfig{XhrThreads: 10}
+	vc.XhrCache.Enable = true
+	vmMgr, err := v8.NewVmMgr("dev", "", callback, vc, nil)
+	if err != nil {
+		t.Fatalf("create vm mgr err: %v", err)
+	}
+
+	code := `
+(function() {
+	const base = ` + "`" + ts.URL + "`" + `;
+	const report = (name, ok) => v8goGo.sendMessage(0, 0, name + (ok ? ':ok' : ':fail'), '', '', '');
+	for (let i = 0; i < 4; i++) {
+		fetch(base + '/config').then((resp) => resp.text()).then((text) => report('config', text === 'config'));
+		fetch(base + '/private').then((resp) => resp.text()).then((text) => report('private', text === 'private'));
+	}
+})();
+`
+	run := func(n int) {
+		_, err = vmMgr.Execute(0, code, "test.js")
+		if err != nil {
+			t.Fatalf("test fail: %v", err)
+		}
+		for i := 0; i < n; i++ {
+			select {
+			case r := <-results:
+				if len(r) < 3 || r[len(r)-3:] != ":ok" {
+					t.Errorf("xhr cache test failed: %s", r)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("xhr cache test timeout")
+			}
+		}
+	}
+
+	run(8)
+	if n := atomic.LoadInt32(&configHits); n != 1 {
+		t.Errorf("coalesced requests hit the backend %d times", n)
+	}
+	if n := atomic.LoadInt32(&privateHits); n != 4 {
+		t.Errorf("private requests hit the backend %d times", n)
+	}
+
+	run(8)
+	if n := atomic.LoadInt32(&configHits); n != 1 {
+		t.Errorf("cached requests hit the backend %d times", n)
+	}
+	if n := atomic.LoadInt32(&privateHits); n != 8 {
+		t.Errorf("private requests hit the backend %d times", n)
+	}
+}
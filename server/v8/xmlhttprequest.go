@@ -1,11 +1,19 @@
 package v8
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/alarm"
 	"github.com/lizc2003/vue-ssr-v8go/server/common/tlog"
+	"github.com/lizc2003/vue-ssr-v8go/server/common/trace"
+	"golang.org/x/text/encoding/htmlindex"
 	"io"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -14,11 +22,6 @@ import (
 	"time"
 )
 
-type OriginRewrite struct {
-	OriginHost string
-	RewriteUrl *url.URL
-}
-
 type xhrCmd struct {
 	Cmd            string            `json:"cmd"`
 	XhrId          int               `json:"xhr_id"`
@@ -26,40 +29,81 @@ type xhrCmd struct {
 	Method         string            `json:"method"`
 	Headers        map[string]string `json:"headers"`
 	Post           string            `json:"post"`
+	PostEncoding   string            `json:"post_encoding"`
 	Timeout        int               `json:"timeout"`
+	ResponseType   string            `json:"response_type"`
 	reqUrl         *url.URL
+	renderId       int64
 	worker         *Worker
+	ctx            context.Context
+	cancel         context.CancelFunc
 	aborted        bool
 	beginTime      time.Time
 	queueBeginTime time.Time
+	reported       bool
+	deniedErr      error
+}
+
+// report hands the xhr time to the render. It must be called before the
+// last event is sent, as the render may finish as soon as js receives it.
+func (this *xhrCmd) report() {
+	if this.reported || this.renderId <= 0 || this.worker.callback == nil {
+		return
+	}
+	this.reported = true
+	elapse := time.Since(this.beginTime)
+	this.worker.callback(15, this.renderId, strconv.FormatInt(int64(elapse), 10), "", "", "")
 }
 
 type XmlHttpRequestMgr struct {
-	mutex sync.Mutex
-	queue chan *xhrCmd
-	reqs  map[int]*xhrCmd
-	maxId int
+	mutex        sync.Mutex
+	queue        chan *xhrCmd
+	reqs         map[int]*xhrCmd
+	maxId        int
+	client       *http.Client
+	timeout      time.Duration
+	rewriteRules []RewriteRule
+	policy       *OutboundPolicy
+	cache        *XhrCache
 }
 
-func NewXmlHttpRequestMgr(xhrThreads int32, originRewrite *OriginRewrite) (*XmlHttpRequestMgr, error) {
+func NewXmlHttpRequestMgr(vc *VmConfig, rewriteRules []RewriteRule) (*XmlHttpRequestMgr, error) {
+	xhrThreads := vc.XhrThreads
 	if xhrThreads < MinXhrThreads {
 		xhrThreads = MinXhrThreads
 	} else if xhrThreads > MaxXhrThreads {
 		xhrThreads = MaxXhrThreads
 	}
 
-	httpClient := newHttpClient()
+	policy, err := NewOutboundPolicy(&vc.Outbound)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newHttpClient(&vc.HttpClient, policy)
+	if err != nil {
+		return nil, err
+	}
+
 	queue := make(chan *xhrCmd, xhrThreads*2)
 	reqs := make(map[int]*xhrCmd)
 	mgr := &XmlHttpRequestMgr{
-		queue: queue,
-		reqs:  reqs,
+		queue:        queue,
+		reqs:         reqs,
+		client:       client,
+		timeout:      time.Duration(orDefault(vc.HttpClient.Timeout, DefaultXhrTimeout)) * time.Second,
+		rewriteRules: rewriteRules,
+		policy:       policy,
+		cache:        NewXhrCache(&vc.XhrCache),
+	}
+	if policy != nil {
+		client.CheckRedirect = mgr.checkRedirect
 	}
 
 	for i := int32(0); i < xhrThreads; i++ {
 		go func() {
 			for req := range queue {
-				performXhr(req, httpClient, originRewrite)
+				mgr.performXhr(req)
+				req.cancel()
 
 				mgr.mutex.Lock()
 				delete(mgr.reqs, req.XhrId)
@@ -78,6 +122,18 @@ func (this *XmlHttpRequestMgr) Open(req *xhrCmd) int {
 	}
 
 	req.reqUrl = reqUrl
+	// the rewrite targets are set by the server, not built by the page
+	if this.policy != nil && matchRewriteRule(this.rewriteRules, reqUrl) == nil {
+		if err := this.policy.Check(reqUrl); err != nil {
+			req.deniedErr = fmt.Errorf("%s: %w", reqUrl.String(), err)
+		}
+	}
+	// like the browser, the timeout runs from send, including the queue wait
+	timeout := time.Duration(req.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = this.timeout
+	}
+	req.ctx, req.cancel = context.WithTimeout(context.Background(), timeout)
 
 	this.mutex.Lock()
 	this.maxId++
@@ -91,22 +147,49 @@ func (this *XmlHttpRequestMgr) Open(req *xhrCmd) int {
 	return req.XhrId
 }
 
+// checkRedirect applies the outbound policy to the redirects, which the
+// page didn't ask for.
+func (this *XmlHttpRequestMgr) checkRedirect(request *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if isOutboundExempt(request.Context(), hostPort(request.URL)) {
+		return nil
+	}
+	return this.policy.Check(request.URL)
+}
+
 func (this *XmlHttpRequestMgr) Abort(xhrId int) {
 	this.mutex.Lock()
 	if req, ok := this.reqs[xhrId]; ok {
 		req.aborted = true
+		req.cancel()
 	}
 	this.mutex.Unlock()
 }
 
-func performXhr(req *xhrCmd, client *http.Client, originRewrite *OriginRewrite) {
-	renderId := int64(0)
-	if rId, ok := req.Headers["SSR-Render-ID"]; ok {
-		renderId, _ = strconv.ParseInt(rId, 10, 64)
-		delete(req.Headers, "SSR-Render-ID")
+// AbortRender aborts all the xhrs of a render and returns them.
+func (this *XmlHttpRequestMgr) AbortRender(renderId int64) []*xhrCmd {
+	var ret []*xhrCmd
+	this.mutex.Lock()
+	for _, req := range this.reqs {
+		if req.renderId == renderId && !req.aborted {
+			req.aborted = true
+			req.cancel()
+			ret = append(ret, req)
+		}
 	}
+	this.mutex.Unlock()
+	return ret
+}
+
+func (this *XmlHttpRequestMgr) performXhr(req *xhrCmd) {
+	renderId := req.renderId
 
 	defer func(t time.Time, renderId int64, u string) {
+		req.report()
+		metricXhrDuration.Observe(time.Since(req.beginTime).Seconds())
+		metricXhrQueueWait.Observe(t.Sub(req.queueBeginTime).Seconds())
 		tlog.Infof("xhr %d-%d: %s, total: %v, push: %v, queue: %v", renderId, req.XhrId, u,
 			time.Since(req.beginTime),
 			req.queueBeginTime.Sub(req.beginTime),
@@ -114,6 +197,15 @@ func performXhr(req *xhrCmd, client *http.Client, originRewrite *OriginRewrite)
 		)
 	}(time.Now(), renderId, req.reqUrl.String())
 
+	var span *trace.Span
+	if sc, ok := ThisVmMgr.renderTraces.Load(renderId); ok {
+		span = trace.StartWithParent(sc.(trace.SpanContext), "xhr "+req.Method, trace.SpanKindClient)
+		span.SetAttr("http.request.method", req.Method)
+		span.SetAttr("url.full", req.reqUrl.String())
+		span.SetAttr("server.address", req.reqUrl.Host)
+		defer span.End()
+	}
+
 	worker := req.worker
 	evt := xhrEvent{XhrId: req.XhrId, renderId: renderId}
 
@@ -125,39 +217,57 @@ func performXhr(req *xhrCmd, client *http.Client, originRewrite *OriginRewrite)
 	evt.Event = "onstart"
 	sendXhrEvent(worker, &evt)
 
-	isOrigin := false
+	if req.deniedErr != nil {
+		span.SetError(req.deniedErr)
+		sendXhrErrorEvent(worker, &evt, req.deniedErr)
+		return
+	}
+
+	xhrUrl := req.reqUrl.String()
 	reqURL := req.reqUrl
-	if originRewrite != nil && reqURL.Host == originRewrite.OriginHost {
-		isOrigin = true
-		if originRewrite.RewriteUrl != nil {
-			reqURL.Scheme = originRewrite.RewriteUrl.Scheme
-			reqURL.Host = originRewrite.RewriteUrl.Host
-		}
+	hostHeader := ""
+	bForwardCookies := false
+	ctx := req.ctx
+	if rule := matchRewriteRule(this.rewriteRules, reqURL); rule != nil {
+		hostHeader = rule.apply(reqURL)
+		bForwardCookies = rule.ForwardCookies
+		ctx = withOutboundExempt(ctx, reqURL)
 	}
 	realRequestUrl := reqURL.String()
 
-	var request *http.Request
-	var err error
-	if len(req.Post) == 0 {
-		request, err = http.NewRequest(req.Method, realRequestUrl, nil)
-	} else {
-		request, err = http.NewRequest(req.Method, realRequestUrl, strings.NewReader(req.Post))
-		if _, ok := req.Headers["Content-Type"]; !ok {
-			c := req.Post[0]
-			if c == '{' || c == '[' {
-				req.Headers["Content-Type"] = "application/json;charset=UTF-8"
-			} else {
-				req.Headers["Content-Type"] = "application/x-www-form-urlencoded"
+	var body io.Reader
+	if len(req.Post) > 0 {
+		_, hasContentType := req.Headers["Content-Type"]
+		if req.PostEncoding == "base64" {
+			post, err := base64.StdEncoding.DecodeString(req.Post)
+			if err != nil {
+				sendXhrErrorEvent(worker, &evt, err)
+				return
+			}
+			body = bytes.NewReader(post)
+			if !hasContentType {
+				req.Headers["Content-Type"] = "application/octet-stream"
+			}
+		} else {
+			body = strings.NewReader(req.Post)
+			if !hasContentType {
+				c := req.Post[0]
+				if c == '{' || c == '[' {
+					req.Headers["Content-Type"] = "application/json;charset=UTF-8"
+				} else {
+					req.Headers["Content-Type"] = "application/x-www-form-urlencoded"
+				}
 			}
 		}
 	}
+	request, err := http.NewRequestWithContext(ctx, req.Method, realRequestUrl, body)
 	if err != nil {
 		sendXhrErrorEvent(worker, &evt, err)
 		return
 	}
 
-	if isOrigin {
-		request.Host = originRewrite.OriginHost
+	if hostHeader != "" {
+		request.Host = hostHeader
 	}
 
 	for k, v := range req.Headers {
@@ -168,7 +278,7 @@ func performXhr(req *xhrCmd, client *http.Client, originRewrite *OriginRewrite)
 				if err == nil {
 					for kk, vv := range headers {
 						if vv != "" {
-							if kk == "Cookie" && !isOrigin {
+							if (kk == "Cookie" || kk == "Authorization") && !bForwardCookies {
 								continue
 							}
 							tlog.Debugf("ssr header %s: %s", kk, vv)
@@ -181,12 +291,46 @@ func performXhr(req *xhrCmd, client *http.Client, originRewrite *OriginRewrite)
 			request.Header.Set(k, v)
 		}
 	}
+	if span != nil {
+		request.Header.Set("Traceparent", span.SpanContext().Traceparent())
+	}
 	if req.aborted {
 		sendXhrFinishEvent(worker, &evt)
 		return
 	}
 
-	resp, err := client.Do(request)
+	var result *xhrResult
+	fetch := func(stale *xhrResult) (*xhrResult, error) {
+		r := request
+		if stale != nil {
+			r = request.Clone(request.Context())
+			if etag := stale.header.Get("ETag"); etag != "" {
+				r.Header.Set("If-None-Match", etag)
+			}
+			if lastModified := stale.header.Get("Last-Modified"); lastModified != "" {
+				r.Header.Set("If-Modified-Since", lastModified)
+			}
+		}
+		return this.doRequest(r)
+	}
+	cacheKey := ""
+	if this.cache != nil {
+		cacheKey = this.cache.Key(request)
+	}
+	if cacheKey != "" {
+		var state string
+		result, state, err = this.cache.Do(req.ctx, cacheKey, xhrUrl, fetch)
+		metricXhrCache.Inc(state)
+		span.SetAttr("xhr.cache", state)
+	} else {
+		result, err = fetch(nil)
+	}
+	req.report()
+	if err != nil {
+		span.SetError(err)
+	} else {
+		span.SetAttr("http.response.status_code", result.status)
+	}
 	if req.aborted {
 		sendXhrFinishEvent(worker, &evt)
 		return
@@ -195,36 +339,101 @@ func performXhr(req *xhrCmd, client *http.Client, originRewrite *OriginRewrite)
 		sendXhrErrorEvent(worker, &evt, err)
 		return
 	}
-	if resp == nil {
-		err = fmt.Errorf("response is nil: %s", realRequestUrl)
-		sendXhrErrorEvent(worker, &evt, err)
-		return
+
+	// the Set-Cookie headers of the responses of rules forwarding cookies, like
+	// the origin, are handed to the render, which decides whether to forward
+	// them to the browser.
+	if bForwardCookies && renderId > 0 && ThisVmMgr.ForwardSetCookie && worker.callback != nil {
+		for _, sc := range result.header.Values("Set-Cookie") {
+			worker.callback(14, renderId, sc, "", "", "")
+		}
 	}
 
 	evt.Event = "onheader"
-	evt.Status = int32(resp.StatusCode)
+	evt.Status = int32(result.status)
 	evt.Headers = make(map[string]string)
-	for k, v := range resp.Header {
+	for k, v := range result.header {
 		evt.Headers[k] = strings.Join(v, "&")
 	}
 	sendXhrEvent(worker, &evt)
 
+	evt.Event = "onend"
+	evt.Response, evt.Encoding = encodeXhrResponse(result.body, result.header.Get("Content-Type"), req.ResponseType)
+	sendXhrEvent(worker, &evt)
+
+	sendXhrFinishEvent(worker, &evt)
+}
+
+// doRequest performs request and reads the whole response.
+func (this *XmlHttpRequestMgr) doRequest(request *http.Request) (*xhrResult, error) {
+	resp, err := this.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, fmt.Errorf("response is nil: %s", request.URL.String())
+	}
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
-	if req.aborted {
-		sendXhrFinishEvent(worker, &evt)
-		return
-	}
 	if err != nil {
-		sendXhrErrorEvent(worker, &evt, err)
-		return
+		return nil, err
 	}
+	return &xhrResult{status: resp.StatusCode, header: resp.Header, body: body}, nil
+}
 
-	evt.Event = "onend"
-	evt.Response = string(body)
-	sendXhrEvent(worker, &evt)
+// encodeXhrResponse converts the body for js. Binary responses are sent in
+// base64, as a js string can't carry raw bytes; text is decoded to utf-8
+// from the charset of the content type. The response type "auto" is used
+// by fetch, which picks binary or text by the content type.
+func encodeXhrResponse(body []byte, contentType string, responseType string) (string, string) {
+	switch responseType {
+	case "arraybuffer", "blob":
+		return base64.StdEncoding.EncodeToString(body), "base64"
+	case "auto":
+		if !isTextContentType(contentType) {
+			return base64.StdEncoding.EncodeToString(body), "base64"
+		}
+	}
+	return decodeCharset(body, contentType), ""
+}
 
-	sendXhrFinishEvent(worker, &evt)
+func isTextContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-www-form-urlencoded", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// decodeCharset decodes body to utf-8. Unknown charsets are passed through.
+func decodeCharset(body []byte, contentType string) string {
+	_, params, _ := mime.ParseMediaType(contentType)
+	charset := strings.ToLower(strings.TrimSpace(params["charset"]))
+	if charset == "" || charset == "utf-8" || charset == "utf8" {
+		return string(body)
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		tlog.Warnf("xhr unknown charset: %s", charset)
+		return string(body)
+	}
+	decoded, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return string(body)
+	}
+	return string(decoded)
 }
 
 func sendXhrFinishEvent(w *Worker, evt *xhrEvent) {
@@ -233,16 +442,31 @@ func sendXhrFinishEvent(w *Worker, evt *xhrEvent) {
 }
 
 func sendXhrErrorEvent(w *Worker, evt *xhrEvent, err error) {
-	tlog.Error(err)
-	go alarm.SendAlert(err.Error())
+	if errors.Is(err, ErrorXhrDenied) {
+		// not worth an alert, the page asked for it
+		tlog.Warn(err)
+		metricXhrDenied.Inc()
+	} else {
+		tlog.Error(err)
+		go alarm.SendAlert(err.Error())
+	}
+	metricXhrErrors.Inc()
 
 	evt.Event = "onerror"
+	if isTimeoutError(err) {
+		evt.Event = "ontimeout"
+	}
 	evt.Error = err.Error()
 	sendXhrEvent(w, evt)
 
 	sendXhrFinishEvent(w, evt)
 }
 
+func isTimeoutError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
 func sendXhrEvent(w *Worker, evt *xhrEvent) {
 	w.SendXhrEvent(evt)
 	evt.Reset()
@@ -259,6 +483,14 @@ func handleXMLHttpRequestCmd(w *Worker, msg string) string {
 
 	switch req.Cmd {
 	case "open":
+		req.Headers = normalizeXhrHeaders(req.Headers)
+		req.renderId = w.renderId
+		if rId, ok := req.Headers["SSR-Render-ID"]; ok {
+			if renderId, err := strconv.ParseInt(rId, 10, 64); err == nil {
+				req.renderId = renderId
+			}
+			delete(req.Headers, "SSR-Render-ID")
+		}
 		xhrId := ThisVmMgr.xhrMgr.Open(&req)
 		return strconv.FormatInt(int64(xhrId), 10)
 	case "abort":
@@ -269,3 +501,18 @@ func handleXMLHttpRequestCmd(w *Worker, msg string) string {
 	tlog.Errorf("unknown xhr cmd: %s", req.Cmd)
 	return ""
 }
+
+func normalizeXhrHeaders(headers map[string]string) map[string]string {
+	ret := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if strings.EqualFold(k, "SSR-Render-ID") {
+			k = "SSR-Render-ID"
+		} else if strings.EqualFold(k, "SSR-Headers") {
+			k = "SSR-Headers"
+		} else {
+			k = http.CanonicalHeaderKey(k)
+		}
+		ret[k] = v
+	}
+	return ret
+}
@@ -2,6 +2,8 @@ package v8_test
 
 import (
 	v8 "github.com/lizc2003/vue-ssr-v8go/server/v8"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -13,7 +15,7 @@ func TestXhr(t *testing.T) {
 		return
 	}
 
-	_, err = vmMgr.Execute(testXhrJsContent, "test.js")
+	_, err = vmMgr.Execute(0, testXhrJsContent, "test.js")
 	if err != nil {
 		t.Fatalf("test fail: %v", err)
 		return
@@ -22,6 +24,135 @@ func TestXhr(t *testing.T) {
 	time.Sleep(10 * time.Second)
 }
 
+func TestXhrResponseType(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bin":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte{0, 1, 0x80, 0xfe, 0xff})
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":1}`))
+		case "/gbk":
+			w.Header().Set("Content-Type", "text/plain; charset=gbk")
+			w.Write([]byte{0xd6, 0xd0, 0xce, 0xc4})
+		}
+	}))
+	defer ts.Close()
+
+	results := make(chan string, 10)
+	callback := func(mtype int64, param1 int64, param2 string, param3 string, param4 string, param5 string) {
+		results <- param2
+	}
+	vmMgr, err := v8.NewVmMgr("dev", "", callback, &v8.VmConfig{}, nil)
+	if err != nil {
+		t.Fatalf("create vm mgr err: %v", err)
+	}
+
+	code := `
+(function() {
+	const base = ` + "`" + ts.URL + "`" + `;
+	const report = (name, ok) => v8goGo.sendMessage(0, 0, name + (ok ? ':ok' : ':fail'), '', '', '');
+	const isBin = (buf) => {
+		let b = new Uint8Array(buf);
+		return b.length === 5 && b[0] === 0 && b[1] === 1 && b[2] === 0x80 && b[3] === 0xfe && b[4] === 0xff;
+	};
+	const get = (path, type, onload) => {
+		let xhr = new XMLHttpRequest();
+		xhr.open('GET', base + path);
+		xhr.responseType = type;
+		xhr.onload = () => onload(xhr);
+		xhr.send();
+	};
+
+	get('/bin', 'arraybuffer', (xhr) => report('arraybuffer', xhr.response instanceof ArrayBuffer && isBin(xhr.response)));
+	get('/bin', 'blob', (xhr) => {
+		xhr.response.arrayBuffer().then((buf) => report('blob', xhr.response.type === 'application/octet-stream' && isBin(buf)));
+	});
+	get('/json', 'json', (xhr) => report('json', xhr.response.id === 1 && xhr.responseText === ''));
+	get('/gbk', '', (xhr) => report('charset', xhr.responseText === '中文'));
+	fetch(base + '/bin').then((resp) => resp.arrayBuffer()).then((buf) => report('fetch-bin', isBin(buf)));
+})();
+`
+	_, err = vmMgr.Execute(0, code, "test.js")
+	if err != nil {
+		t.Fatalf("test fail: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		select {
+		case r := <-results:
+			t.Log(r)
+			if len(r) < 3 || r[len(r)-3:] != ":ok" {
+				t.Errorf("xhr response type test failed: %s", r)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("xhr response type test timeout")
+		}
+	}
+}
+
+func TestXhrTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slower" {
+			time.Sleep(1500 * time.Millisecond)
+		} else {
+			time.Sleep(500 * time.Millisecond)
+		}
+		w.Write([]byte("slow"))
+	}))
+	defer ts.Close()
+
+	results := make(chan string, 10)
+	callback := func(mtype int64, param1 int64, param2 string, param3 string, param4 string, param5 string) {
+		if mtype == 0 {
+			results <- param2
+		}
+	}
+	vmMgr, err := v8.NewVmMgr("dev", "", callback, &v8.VmConfig{InstanceLifetime: 60, HttpClient: v8.HttpClientConfig{Timeout: 1}}, nil)
+	if err != nil {
+		t.Fatalf("create vm mgr err: %v", err)
+	}
+
+	code := `
+(function() {
+	const base = ` + "`" + ts.URL + "`" + `;
+	const report = (name, ok) => v8goGo.sendMessage(0, 0, name + (ok ? ':ok' : ':fail'), '', '', '');
+	const get = (name, path, timeout, expected) => {
+		let xhr = new XMLHttpRequest();
+		xhr.open('GET', base + path);
+		if (timeout > 0) {
+			xhr.timeout = timeout;
+		}
+		xhr.onload = () => report(name, expected === 'load' && xhr.responseText === 'slow');
+		xhr.ontimeout = () => report(name, expected === 'timeout');
+		xhr.onerror = () => report(name, false);
+		xhr.send();
+	};
+
+	get('timeout', '/slow', 100, 'timeout');
+	get('no-timeout', '/slow', 3000, 'load');
+	get('default-timeout', '/slower', 0, 'timeout');
+})();
+`
+	_, err = vmMgr.Execute(0, code, "test.js")
+	if err != nil {
+		t.Fatalf("test fail: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case r := <-results:
+			t.Log(r)
+			if len(r) < 3 || r[len(r)-3:] != ":ok" {
+				t.Errorf("xhr timeout test failed: %s", r)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("xhr timeout test timeout")
+		}
+	}
+}
+
 const testXhrJsContent = `
 var assert = function (condition, message) {
   if (!condition) {